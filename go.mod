@@ -3,3 +3,5 @@ module github.com/Zesheng-Xu/SPAKE2-playground
 go 1.21.5
 
 require golang.org/x/crypto v0.22.0
+
+require golang.org/x/sys v0.19.0 // indirect
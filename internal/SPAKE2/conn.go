@@ -0,0 +1,358 @@
+package spake2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+const (
+	// maxPlaintext is the most a single record carries, longer writes are split
+	maxPlaintext = 16384
+
+	// maxFrame bounds what we are willing to read for one frame, records are at most
+	// maxPlaintext plus the record type, the sequence number and the AEAD overhead
+	maxFrame = 1 << 15
+
+	// DefaultKeyUpdateRecords keeps well below the 2^24.5 full size records RFC 8446 section 5.5 allows per AES-GCM key
+	DefaultKeyUpdateRecords = 1 << 24
+
+	// DefaultKeyUpdateBytes is how much plaintext we send under one key unless configured otherwise
+	DefaultKeyUpdateBytes = 1 << 32
+)
+
+// record types, the type is the first byte of every record's plaintext so it is encrypted too
+const (
+	recordData      byte = 0
+	recordKeyUpdate byte = 1
+)
+
+// KeyUpdate bodies, like the KeyUpdateRequest of TLS 1.3
+const (
+	updateNotRequested byte = 0
+	updateRequested    byte = 1
+)
+
+var (
+	// ErrFrameTooLarge is returned when the peer announces a frame longer than maxFrame
+	ErrFrameTooLarge = errors.New("spake2: frame too large")
+
+	// ErrRecordOutOfOrder is returned when a stream record isn't the next one, records of a stream
+	// can't legitimately be reordered or dropped
+	ErrRecordOutOfOrder = errors.New("spake2: record out of order")
+
+	// ErrUnexpectedRecord is returned for a record of unknown type or a malformed KeyUpdate
+	ErrUnexpectedRecord = errors.New("spake2: unexpected record")
+)
+
+// Config sets up one side of a Conn
+type Config struct {
+	Identity     string
+	PeerIdentity string
+	Password     string
+	Suite        suite.SuiteOptions
+	Rand         io.Reader // source of randomness, crypto/rand when nil
+
+	// KeyUpdateRecords and KeyUpdateBytes are how many records or plaintext bytes we send before
+	// moving to a new key, zero uses DefaultKeyUpdateRecords and DefaultKeyUpdateBytes
+	KeyUpdateRecords uint64
+	KeyUpdateBytes   uint64
+}
+
+// Conn is a net.Conn secured by a SPAKE2 handshake, Read and Write go through the session's records.
+// Deadlines and addresses are the ones of the underlying connection.
+type Conn struct {
+	net.Conn
+	session       *Session
+	readMu        sync.Mutex
+	writeMu       sync.Mutex
+	pending       []byte // opened plaintext not read yet
+	readSeq       uint64 // sequence number of the next record we expect
+	updateRecords uint64 // records sent under one key before updating it
+	updateBytes   uint64 // plaintext bytes sent under one key before updating it
+	sentRecords   uint64 // records sent under the current key
+	sentBytes     uint64 // plaintext bytes sent under the current key
+}
+
+// Client runs the client side of SPAKE2 over conn, then returns the connection encrypted with the derived keys
+func Client(conn net.Conn, cfg *Config) (*Conn, error) {
+	return handshakeConn(conn, suite.Client, cfg)
+}
+
+// Server runs the server side of SPAKE2 over conn, then returns the connection encrypted with the derived keys
+func Server(conn net.Conn, cfg *Config) (*Conn, error) {
+	return handshakeConn(conn, suite.Server, cfg)
+}
+
+// handshakeConn runs the handshake the same way as the HTTP endpoints: the client sends its share first
+// and the server only sends its confirmation once the client's checked out, so neither side
+// has to write while the other one does
+func handshakeConn(conn net.Conn, role suite.Role, cfg *Config) (*Conn, error) {
+	participant, err := NewParticipant(role, cfg.Identity, &SetUpParams{
+		Pw:               cfg.Password,
+		OpponentIdentity: cfg.PeerIdentity,
+		Suite:            cfg.Suite,
+		Rand:             cfg.Rand,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the session keeps what it needs, the handshake secrets go once it's over either way
+	defer participant.Close()
+
+	share, err := participant.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	var session *Session
+	if role == suite.Client {
+		session, err = clientHandshake(conn, participant, share)
+	} else {
+		session, err = serverHandshake(conn, participant, share)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("spake2 handshake with %s: %w", conn.RemoteAddr(), err)
+	}
+
+	c := &Conn{Conn: conn, session: session, updateRecords: cfg.KeyUpdateRecords, updateBytes: cfg.KeyUpdateBytes}
+	if c.updateRecords == 0 {
+		c.updateRecords = DefaultKeyUpdateRecords
+	}
+	if c.updateBytes == 0 {
+		c.updateBytes = DefaultKeyUpdateBytes
+	}
+
+	return c, nil
+}
+
+func clientHandshake(conn net.Conn, h Handshake, share []byte) (*Session, error) {
+	if err := writeFrame(conn, share); err != nil {
+		return nil, err
+	}
+
+	peerShare, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	confirm, err := h.Respond(peerShare)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, confirm); err != nil {
+		return nil, err
+	}
+
+	peerConfirm, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Finish(peerConfirm)
+}
+
+func serverHandshake(conn net.Conn, h Handshake, share []byte) (*Session, error) {
+	peerShare, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, share); err != nil {
+		return nil, err
+	}
+
+	confirm, err := h.Respond(peerShare)
+	if err != nil {
+		return nil, err
+	}
+
+	peerConfirm, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := h.Finish(peerConfirm)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, writeFrame(conn, confirm)
+}
+
+// Session returns the session protecting the connection
+func (c *Conn) Session() *Session {
+	return c.session
+}
+
+// Close closes the underlying connection and wipes the session's keys
+func (c *Conn) Close() error {
+	c.session.Close()
+	return c.Conn.Close()
+}
+
+// Read returns plaintext from the peer, opening the next record when nothing is pending.
+// KeyUpdate records are handled on the way, they don't show up in what Read returns.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.pending) == 0 {
+		record, err := readFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(record) < seqLen || binary.BigEndian.Uint64(record) != c.readSeq {
+			return 0, ErrRecordOutOfOrder
+		}
+
+		plaintext, err := c.session.Open(record, nil)
+		if err != nil {
+			return 0, err
+		}
+		c.readSeq++
+
+		if len(plaintext) == 0 {
+			return 0, ErrUnexpectedRecord
+		}
+		switch plaintext[0] {
+		case recordData:
+			c.pending = plaintext[1:]
+		case recordKeyUpdate:
+			if err := c.handleKeyUpdate(plaintext[1:]); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, ErrUnexpectedRecord
+		}
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// handleKeyUpdate moves to the peer's next key, every following record is sealed under it.
+// When the peer asks us to update too we answer right away, like RFC 8446 section 4.6.3 has it,
+// without waiting for data of our own to send.
+func (c *Conn) handleKeyUpdate(body []byte) error {
+	if len(body) != 1 || body[0] > updateRequested {
+		return ErrUnexpectedRecord
+	}
+
+	if err := c.session.UpdateReceiveKey(); err != nil {
+		return err
+	}
+	c.readSeq = 0
+
+	if body[0] == updateRequested {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+
+		return c.sendKeyUpdate(updateNotRequested)
+	}
+
+	return nil
+}
+
+// Write seals b in records of at most maxPlaintext bytes and sends them, updating our key
+// whenever the configured limits are reached
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for written < len(b) {
+		if c.sentRecords >= c.updateRecords || c.sentBytes >= c.updateBytes {
+			if err := c.sendKeyUpdate(updateNotRequested); err != nil {
+				return written, err
+			}
+		}
+
+		chunk := b[written:min(len(b), written+maxPlaintext)]
+		if err := c.writeRecord(recordData, chunk); err != nil {
+			return written, err
+		}
+
+		c.sentRecords++
+		c.sentBytes += uint64(len(chunk))
+		written += len(chunk)
+	}
+
+	return written, nil
+}
+
+// UpdateKey moves our send key forward now, with requestPeer the peer updates its own key in return
+func (c *Conn) UpdateKey(requestPeer bool) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	request := updateNotRequested
+	if requestPeer {
+		request = updateRequested
+	}
+
+	return c.sendKeyUpdate(request)
+}
+
+// sendKeyUpdate announces the update under the old key and then switches, c.writeMu must be held
+func (c *Conn) sendKeyUpdate(request byte) error {
+	if err := c.writeRecord(recordKeyUpdate, []byte{request}); err != nil {
+		return err
+	}
+
+	if err := c.session.UpdateSendKey(); err != nil {
+		return err
+	}
+
+	c.sentRecords, c.sentBytes = 0, 0
+
+	return nil
+}
+
+// writeRecord seals one record of the given type and sends it as a frame
+func (c *Conn) writeRecord(recordType byte, body []byte) error {
+	record, err := c.session.Seal(append([]byte{recordType}, body...), nil)
+	if err != nil {
+		return err
+	}
+
+	return writeFrame(c.Conn, record)
+}
+
+// writeFrame sends a 4 byte big endian length followed by the payload
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+
+	return err
+}
+
+// readFrame reads one length prefixed frame
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length > maxFrame {
+		return nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+var _ net.Conn = (*Conn)(nil)
@@ -0,0 +1,148 @@
+package spake2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// TestConn runs Client and Server over a pipe and echoes a message spanning several records through them,
+// with a wrong password the server must refuse the client. The client updates its key every two records
+// and asks the server to update as well.
+func TestConn(t *testing.T) {
+	message := bytes.Repeat([]byte("conn test "), 4000)
+
+	for _, serverPw := range []string{"password", "wrong password"} {
+		t.Run(serverPw, func(t *testing.T) {
+			clientEnd, serverEnd := net.Pipe()
+
+			echoed := make(chan error, 1)
+			go func() {
+				defer serverEnd.Close()
+
+				conn, err := spake2.Server(serverEnd, &spake2.Config{Identity: "server", PeerIdentity: "client", Password: serverPw, Suite: suite.P256})
+				if err != nil {
+					echoed <- err
+					return
+				}
+
+				buf := make([]byte, len(message))
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					echoed <- err
+					return
+				}
+				_, err = conn.Write(buf)
+				echoed <- err
+			}()
+
+			err := echoConn(clientEnd, message)
+			clientEnd.Close()
+			serverErr := <-echoed
+
+			if serverPw != "password" {
+				if !errors.Is(serverErr, spake2.ErrConfirmationFailed) {
+					t.Fatalf("wrong password: got %v, want %v", serverErr, spake2.ErrConfirmationFailed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if serverErr != nil {
+				t.Fatal(serverErr)
+			}
+		})
+	}
+}
+
+// echoConn sends message as the client and checks the same message comes back
+func echoConn(c net.Conn, message []byte) error {
+	conn, err := spake2.Client(c, &spake2.Config{
+		Identity:         "client",
+		PeerIdentity:     "server",
+		Password:         "password",
+		Suite:            suite.P256,
+		KeyUpdateRecords: 2,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := conn.UpdateKey(true); err != nil {
+		return err
+	}
+
+	// the server answers the update while we write, a pipe doesn't buffer so read at the same time
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(message)
+		written <- err
+	}()
+
+	echo := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, echo); err != nil {
+		return err
+	}
+	if err := <-written; err != nil {
+		return err
+	}
+	if !bytes.Equal(echo, message) {
+		return errors.New("echo differs from the message")
+	}
+
+	return nil
+}
+
+// TestKeyUpdateAnswered asks the server to update its key and checks it does so right away, without
+// having data of its own to send
+func TestKeyUpdateAnswered(t *testing.T) {
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	go func() {
+		defer serverEnd.Close()
+
+		conn, err := spake2.Server(serverEnd, &spake2.Config{Identity: "server", PeerIdentity: "client", Password: "password", Suite: suite.P256})
+		if err != nil {
+			return
+		}
+		// only reads, the answer to the update has to go out from in here
+		conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := spake2.Client(clientEnd, &spake2.Config{Identity: "client", PeerIdentity: "server", Password: "password", Suite: suite.P256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.UpdateKey(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// read the server's next record below Conn, it has to be a KeyUpdate that doesn't ask for another
+	if err := clientEnd.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(clientEnd, header[:]); err != nil {
+		t.Fatalf("no answer to the key update: %v", err)
+	}
+	record := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(clientEnd, record); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := conn.Session().Open(record, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, []byte{1, 0}) {
+		t.Fatalf("got record %x, want a KeyUpdate without request (0100)", plaintext)
+	}
+}
@@ -0,0 +1,59 @@
+package spake2
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// exporterLabelPrefix takes the place of TLS 1.3's "tls13 " so our exports never collide with a TLS stack's
+const exporterLabelPrefix = "spake2 "
+
+// ExportKeyingMaterial derives length bytes for the application from the exporter secret, the way
+// RFC 8446 section 7.5 does: HKDF-Expand-Label(Derive-Secret(exporter, label, ""), "exporter", Hash(context), length).
+// Both peers get the same bytes for the same label and context, different labels give independent keys,
+// and none of them can be used to recover the traffic keys or Ke.
+func (s *Session) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+
+	hashLen := s.suite.NewHash().Size()
+	if length < 0 || length > 255*hashLen {
+		return nil, errors.New("spake2: requested exporter length out of range")
+	}
+
+	h := s.suite.NewHash()
+	emptyHash := h.Sum(nil)
+	h.Write(context)
+	contextHash := h.Sum(nil)
+
+	secret, err := s.expandLabel(s.exporter, label, emptyHash, hashLen)
+	if err != nil {
+		return nil, err
+	}
+	defer suite.Wipe(secret)
+
+	return s.expandLabel(secret, "exporter", contextHash, length)
+}
+
+// expandLabel is HKDF-Expand-Label of RFC 8446 section 7.1 with our own label prefix
+func (s *Session) expandLabel(secret []byte, label string, context []byte, length int) ([]byte, error) {
+	fullLabel := exporterLabelPrefix + label
+	if len(fullLabel) > 255 || len(context) > 255 {
+		return nil, errors.New("spake2: exporter label too long")
+	}
+
+	// struct { uint16 length; opaque label<7..255>; opaque context<0..255>; } HkdfLabel
+	info := binary.BigEndian.AppendUint16(nil, uint16(length))
+	info = append(info, byte(len(fullLabel)))
+	info = append(info, fullLabel...)
+	info = append(info, byte(len(context)))
+	info = append(info, context...)
+
+	return expand(s.suite, secret, string(info), length), nil
+}
@@ -0,0 +1,118 @@
+package spake2
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// Handshake is the message oriented view of a PAKE, so applications never touch points, transcripts or keys.
+// Both sides call Start and send the result, pass the peer's message to Respond and send that,
+// then hand the peer's response to Finish to get the Session. Close wipes the handshake's secrets,
+// call it once the handshake failed or is no longer needed, the Session stays usable.
+type Handshake interface {
+	Start() ([]byte, error)
+	Respond(peerMsg []byte) ([]byte, error)
+	Finish(peerMsg []byte) (*Session, error)
+	Close()
+}
+
+// message types, the first byte of every handshake message
+const (
+	msgShare   byte = 1 // pA or pB
+	msgConfirm byte = 2 // MAC(Kc_self, TT)
+)
+
+// ErrUnexpectedMessage is returned when a handshake message is malformed or of the wrong type
+var ErrUnexpectedMessage = errors.New("spake2: unexpected handshake message")
+
+// NewParticipant creates a participant that is set up and ready to Start
+func NewParticipant(role suite.Role, identity string, param *SetUpParams) (*Participant, error) {
+	user := &Participant{Role: role, Identity: identity}
+	if err := user.SetUp(param); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Start computes our share and returns it encoded as the first handshake message
+func (user *Participant) Start() ([]byte, error) {
+	p, err := user.ComputepPoint()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{msgShare}, user.Suite.EncodePoint(p)...), nil
+}
+
+// Respond takes the peer's share, derives the keys and returns our confirmation message
+func (user *Participant) Respond(peerMsg []byte) ([]byte, error) {
+	payload, err := parseMessage(msgShare, peerMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := user.Suite.DecodePoint(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPoint, err)
+	}
+
+	if _, err := user.ComputepGroupElement(peer); err != nil {
+		return nil, err
+	}
+	if _, err := user.ComputeTranscript(); err != nil {
+		return nil, err
+	}
+	if err := user.DeriveKeys(); err != nil {
+		return nil, err
+	}
+
+	mac, err := user.ProduceMacMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{msgConfirm}, mac...), nil
+}
+
+// Finish verifies the peer's confirmation message and returns the established session
+func (user *Participant) Finish(peerMsg []byte) (*Session, error) {
+	payload, err := parseMessage(msgConfirm, peerMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := user.ConfirmMAC(payload)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrConfirmationFailed
+	}
+
+	return user.session, nil
+}
+
+// ConfirmMessage returns our confirmation message again, the same one Respond returned. A server restored
+// from a state token didn't keep it, it sends this once Finish accepted the client's.
+func (user *Participant) ConfirmMessage() ([]byte, error) {
+	mac, err := user.ProduceMacMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{msgConfirm}, mac...), nil
+}
+
+// parseMessage checks the message type and returns the payload
+func parseMessage(want byte, msg []byte) ([]byte, error) {
+	if len(msg) < 2 || msg[0] != want {
+		return nil, ErrUnexpectedMessage
+	}
+
+	return msg[1:], nil
+}
+
+var _ Handshake = (*Participant)(nil)
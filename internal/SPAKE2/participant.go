@@ -1,243 +1,404 @@
 package spake2
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
+	"bytes"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
-	"strconv"
 
 	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
 )
 
+var (
+	// ErrInvalidPoint is returned when the peer's share is not a valid point or leads to the identity
+	ErrInvalidPoint = errors.New("spake2: invalid peer share")
+
+	// ErrConfirmationFailed is returned when the peer's MAC doesn't match, usually a wrong password
+	ErrConfirmationFailed = errors.New("spake2: key confirmation failed")
+)
+
+// Participant is one side of a SPAKE2 handshake. Only public values are exported, the secrets stay
+// unexported so they can't end up in JSON or logs, and Close overwrites them.
 type Participant struct {
 	Suite                   *suite.Suite
-	BigPrime                *big.Int
-	X                       *big.Int // random factor chosen between [0, p)
-	H                       *big.Int
-	W                       *big.Int
+	H                       *big.Int // cofactor of the curve
 	M                       *suite.Point
 	N                       *suite.Point
-	WM                      *suite.Point
-	WN                      *suite.Point
 	Pa                      *suite.Point
 	Pb                      *suite.Point
-	K                       string
-	TT                      string
 	Role                    suite.Role
 	Identity                string
 	OpponentIdentity        string
-	SessionConfirmationKey  []byte
-	ExpectedConfirmationKey []byte
-	SessionPrivateKey       []byte
+	Rand                    io.Reader // source of randomness, crypto/rand when nil
+	x                       *big.Int  // random factor chosen between [0, p) where p is the group order
+	w                       *big.Int
+	wm                      *suite.Point
+	wn                      *suite.Point
+	k                       *suite.Point
+	tt                      []byte // RFC 9382 binary transcript
+	hashedTT                []byte // Hash(TT)
+	sessionConfirmationKey  []byte
+	expectedConfirmationKey []byte
+	sessionPrivateKey       []byte // Ke
+	state                   State
+	session                 *Session // set once the peer's MAC is confirmed
 }
 
 type SetUpParams struct {
 	Pw               string
 	OpponentIdentity string
-	Prime            *big.Int
 	Suite            suite.SuiteOptions
+	W                *big.Int  // precomputed w, Pw is ignored when this is set
+	Rand             io.Reader // source of randomness, crypto/rand when nil
 }
 
-const (
-	mString = "M SPAKE2 seed OID %s"
-	nString = "N SPAKE2 seed OID %s"
-)
-
 // SetUp function sets the shared elements of the SPAKE
-func (user *Participant) SetUp(param *SetUpParams) {
+func (user *Participant) SetUp(param *SetUpParams) error {
+	if err := user.expectState("SetUp", StateInit); err != nil {
+		return err
+	}
+
+	switch user.Role {
+	case suite.Server, suite.Client, suite.Symmetric:
+	default:
+		return fmt.Errorf("spake2: unknown role %q", user.Role)
+	}
 
 	user.Suite = suite.SelectECCSuite(param.Suite)
-	user.BigPrime = param.Prime
+	if user.Suite == nil {
+		return fmt.Errorf("spake2: unknown suite %q", param.Suite)
+	}
+
 	user.OpponentIdentity = param.OpponentIdentity
+	user.Rand = param.Rand
 
-	user.H = new(big.Int).Div(user.Suite.Curve.Params().N, param.Prime)
+	user.H = user.Suite.H
 	user.M, user.N = user.CalculatePublicPoints()
 
-	user.W = user.ComputeW(param.Pw)
+	// our own copy, Close wipes it and the caller may still need theirs
+	if param.W != nil {
+		user.w = new(big.Int).Set(param.W)
+	} else {
+		user.w = user.ComputeW(param.Pw)
+	}
+
+	user.state = StateSetUp
+	return nil
 }
 
-// CalculatePublicPoints calculates M and N used by server and client respectivly
+// CalculatePublicPoints returns the suite's M and N used by server and client respectivly
 func (user *Participant) CalculatePublicPoints() (m, n *suite.Point) {
-	m = user.Suite.HashToCurve(fmt.Sprintf(mString, user.Suite.GetName()))
-	n = user.Suite.HashToCurve(fmt.Sprintf(nString, user.Suite.GetName()))
-
 	switch user.Role {
 	case suite.Server:
-		return m, n
+		return user.Suite.M, user.Suite.N
+	case suite.Symmetric:
+		// the symmetric variant of RFC 9382 blinds both shares with M = N
+		return user.Suite.M, user.Suite.M
 	default:
-		return n, m
+		return user.Suite.N, user.Suite.M
 	}
-
 }
 
 // ComputeW computes W that will be shared between server and client derived from password
 func (user *Participant) ComputeW(pw string) *big.Int {
-	hash := user.Suite.Hash(pw)
+	return DeriveW(user.Suite, pw)
+}
+
+// DeriveW maps a password to w for the suite, a server can keep w and pass it as SetUpParams.W
+// instead of keeping the password
+func DeriveW(s *suite.Suite, pw string) *big.Int {
+	hash := s.Hash(pw)
 	w := new(big.Int).SetBytes(hash[:])
-	w.Mod(w, user.Suite.Curve.Params().P)
+	w.Mod(w, s.Curve.Params().N)
 
 	return w
 }
 
 // ComputepPoint generate special message transmitted to other party for key derivation
 func (user *Participant) ComputepPoint() (p *suite.Point, err error) {
-	x, err := rand.Int(rand.Reader, user.BigPrime)
+	if err := user.expectState("ComputepPoint", StateSetUp); err != nil {
+		return &suite.Point{}, err
+	}
+
+	x, err := rand.Int(user.random(), user.Suite.Curve.Params().N)
 	if err != nil {
 		return &suite.Point{}, err
 	}
 
-	user.X = x
+	user.x = x
 	pointX := user.Suite.BaseMultiply(x)
-	pointWM := user.Suite.Multiply(user.M, user.W)
-	user.WM = pointWM
+	user.wm = user.Suite.Multiply(user.M, user.w).Clone()
+	user.wn = user.Suite.Multiply(user.N, user.w).Clone()
 
-	pointWN := user.Suite.Multiply(user.N, user.W)
-	user.WN = pointWN
-
-	pointP := user.Suite.Add(pointX, pointWM)
-	user.Pa = pointP
+	user.Pa = user.Suite.Add(pointX, user.wm).Clone()
 
+	user.state = StateSent
 	return user.Pa, nil
 }
 
+// random returns the randomness source of the participant
+func (user *Participant) random() io.Reader {
+	if user.Rand == nil {
+		return rand.Reader
+	}
+
+	return user.Rand
+}
+
 // ComputepGroupElement finds K, the shared value across A and B
-func (user *Participant) ComputepGroupElement(b *suite.Point) (k string) {
-	ob := user.Suite.Subtract(b, user.WN)
-	hx := new(big.Int).Mul(user.H, user.X)
+func (user *Participant) ComputepGroupElement(b *suite.Point) (k *suite.Point, err error) {
+	if err := user.expectState("ComputepGroupElement", StateSent); err != nil {
+		return nil, err
+	}
+	if user.Pb != nil {
+		return nil, &StateError{Op: "ComputepGroupElement", State: user.state, Expected: []State{StateSent}, Reason: "peer share already received"}
+	}
 
-	pointK := user.Suite.Multiply(ob, hx)
+	if b == nil || b.IsIdentity() || !user.Suite.IsOnCurve(b) {
+		return nil, ErrInvalidPoint
+	}
 
-	user.Pb = b
+	ob := user.Suite.Subtract(b, user.wn)
+	hx := new(big.Int).Mul(user.H, user.x)
+	defer suite.WipeInt(hx)
+
+	k = user.Suite.Multiply(ob, hx)
+	if k.IsIdentity() {
+		return nil, ErrInvalidPoint
+	}
 
-	user.K = pointK.String()
+	user.k = k.Clone()
+	user.Pb = b
 
-	return user.K
+	return k, nil
 }
 
 // ComputeTranscript creates a TT transcript for this SPAKE2 exchange
-func (user *Participant) ComputeTranscript() (tt string) {
+func (user *Participant) ComputeTranscript() (tt []byte, err error) {
+	if err := user.expectState("ComputeTranscript", StateSent); err != nil {
+		return nil, err
+	}
+	if user.k == nil {
+		return nil, &StateError{Op: "ComputeTranscript", State: user.state, Expected: []State{StateSent}, Reason: "K has not been computed"}
+	}
+
 	// TT = len(A)  || A
 	// || len(B)  || B
 	// || len(pA) || pA
 	// || len(pB) || pB
 	// || len(K)  || K
 	// || len(w)  || w
+	// where every len() is an 8 byte little endian integer
 
 	a, b := "", ""
-	pA, pB := suite.Point{}, suite.Point{}
+	pA, pB := &suite.Point{}, &suite.Point{}
 
-	switch user.Role {
-	case suite.Server:
+	isA, err := user.isA()
+	if err != nil {
+		return nil, err
+	}
+
+	if isA {
 		a = user.Identity
 		b = user.OpponentIdentity
-		pA = *user.Pa
-		pB = *user.Pb
-	case suite.Client:
+		pA = user.Pa
+		pB = user.Pb
+	} else {
 		a = user.OpponentIdentity
 		b = user.Identity
-		pA = *user.Pb
-		pB = *user.Pa
-	default:
-		return ""
+		pA = user.Pb
+		pB = user.Pa
 	}
 
-	user.TT = strconv.Itoa(len(a)) + a +
-		strconv.Itoa(len(b)) + b +
-		strconv.Itoa(len(pA.String())) + pA.String() +
-		strconv.Itoa(len(pB.String())) + pB.String() +
-		strconv.Itoa(len(user.K)) + user.K +
-		strconv.Itoa(len(user.W.String())) + string(user.W.Bytes())
+	t := NewTranscript(user.Suite.NewHash())
+	t.Append([]byte(a))
+	t.Append([]byte(b))
+	t.Append(user.Suite.EncodePoint(pA))
+	t.Append(user.Suite.EncodePoint(pB))
+	t.Append(user.Suite.EncodePoint(user.k))
+	t.Append(user.Suite.EncodeScalar(user.w))
 
-	return user.TT
+	user.tt = t.Bytes()
+	user.hashedTT = t.Sum()
+
+	return user.tt, nil
+}
+
+// isA tells whether this participant takes the place of A in the transcript.
+// The server is A and the client B, in symmetric mode the side whose encoded share sorts first is A
+// so both peers end up with the same canonical transcript.
+func (user *Participant) isA() (bool, error) {
+	switch user.Role {
+	case suite.Server:
+		return true, nil
+	case suite.Client:
+		return false, nil
+	case suite.Symmetric:
+		order := bytes.Compare(user.Suite.EncodePoint(user.Pa), user.Suite.EncodePoint(user.Pb))
+		if order == 0 {
+			return false, ErrInvalidPoint
+		}
+
+		return order < 0, nil
+	default:
+		return false, fmt.Errorf("spake2: unknown role %q", user.Role)
+	}
 }
 
 // DeriveKeys generate 3 byte arrays:
 // Ke: session key that be used to encrypt and decrypt the messages
 // kca: this participant's half of the confirmation key
 // kcb: other participant's half of the confirmation key
-func (user *Participant) DeriveKeys() (ke, kca, kcb []byte) {
+// Ke is only handed out by SessionKey once the peer's MAC has been confirmed
+func (user *Participant) DeriveKeys() error {
+	if err := user.expectState("DeriveKeys", StateSent); err != nil {
+		return err
+	}
+	if len(user.tt) == 0 {
+		return &StateError{Op: "DeriveKeys", State: user.state, Expected: []State{StateSent}, Reason: "transcript has not been computed"}
+	}
 
-	ke, kca, kcb = user.Suite.KDF(user.TT)
+	ke, kca, kcb := user.Suite.KDF(user.hashedTT)
 
-	user.SessionPrivateKey = ke
+	user.sessionPrivateKey = ke
 
-	switch user.Role {
-	case suite.Client:
-		// switch order on client since Client is b
-		user.SessionConfirmationKey = kcb
-		user.ExpectedConfirmationKey = kca
-	default:
-		user.SessionConfirmationKey = kca
-		user.ExpectedConfirmationKey = kcb
+	isA, err := user.isA()
+	if err != nil {
+		return err
 	}
 
-	return ke, kca, kcb
-}
-
-// Encrypt function creates an encrypted text based on drived session key and provided plain text
-func (user *Participant) Encrypt(plainText []byte) ([]byte, error) {
-	block, err := aes.NewCipher(user.SessionPrivateKey)
-	if err != nil {
-		return nil, err
+	if isA {
+		user.sessionConfirmationKey = kca
+		user.expectedConfirmationKey = kcb
+	} else {
+		// switch order on b
+		user.sessionConfirmationKey = kcb
+		user.expectedConfirmationKey = kca
 	}
 
-	ciphertext := make([]byte, aes.BlockSize+len(plainText))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	user.state = StateKeyDerived
+	return nil
+}
+
+// SessionKey returns a copy of Ke, available only after the peer's MAC has been confirmed
+func (user *Participant) SessionKey() ([]byte, error) {
+	if err := user.expectState("SessionKey", StateConfirmed); err != nil {
 		return nil, err
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], plainText)
+	return bytes.Clone(user.sessionPrivateKey), nil
+}
 
-	return ciphertext, nil
+// KeySchedule is a copy of the values a handshake derived from its transcript, RFC 9382 publishes them
+// in its test vectors. It holds secrets, keep it out of logs.
+type KeySchedule struct {
+	TT  []byte
+	Ka  []byte
+	KcA []byte
+	KcB []byte
+	Ke  []byte
 }
 
-// Decrypt function decrypts message received based on derived key
-func (user *Participant) Decrypt(ciphertext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(user.SessionPrivateKey)
+// KeySchedule returns a copy of the derived values. Like SessionKey it is only available once the
+// peer's MAC has been confirmed, and until Close.
+func (user *Participant) KeySchedule() (*KeySchedule, error) {
+	if err := user.expectState("KeySchedule", StateConfirmed); err != nil {
+		return nil, err
+	}
+
+	isA, err := user.isA()
 	if err != nil {
 		return nil, err
 	}
 
-	if len(ciphertext) < aes.BlockSize {
-		return nil, fmt.Errorf("ciphertext too short")
+	kcA, kcB := user.sessionConfirmationKey, user.expectedConfirmationKey
+	if !isA {
+		kcA, kcB = kcB, kcA
 	}
 
-	iv := ciphertext[:aes.BlockSize]
-	ciphertext = ciphertext[aes.BlockSize:]
+	return &KeySchedule{
+		TT:  bytes.Clone(user.tt),
+		Ka:  bytes.Clone(user.hashedTT[len(user.hashedTT)/2:]),
+		KcA: bytes.Clone(kcA),
+		KcB: bytes.Clone(kcB),
+		Ke:  bytes.Clone(user.sessionPrivateKey),
+	}, nil
+}
 
-	stream := cipher.NewCFBDecrypter(block, iv)
-	stream.XORKeyStream(ciphertext, ciphertext)
+// Seal encrypts and authenticates plaintext and aad with the suite's AEAD under the session key
+func (user *Participant) Seal(plaintext, aad []byte) ([]byte, error) {
+	if err := user.expectState("Seal", StateConfirmed); err != nil {
+		return nil, err
+	}
 
-	return ciphertext, nil
+	return user.session.Seal(plaintext, aad)
 }
 
-// ConfirmMAC takes received bytes and check if it matches to this participant's half of confirmation key
+// Open decrypts a message sealed by the peer, tampering fails with ErrAuthenticationFailed
+func (user *Participant) Open(ciphertext, aad []byte) ([]byte, error) {
+	if err := user.expectState("Open", StateConfirmed); err != nil {
+		return nil, err
+	}
+
+	return user.session.Open(ciphertext, aad)
+}
+
+// ConfirmMAC checks the MAC received from the other participant against MAC(Kc_peer, TT), following RFC 9382
+// A wrong MAC aborts the handshake and closes the participant
 func (user *Participant) ConfirmMAC(receivedMAC []byte) (bool, error) {
+	if err := user.expectState("ConfirmMAC", StateKeyDerived); err != nil {
+		return false, err
+	}
 
-	receivedKey, err := user.Decrypt(receivedMAC)
+	if !user.Suite.VerifyTag(user.expectedConfirmationKey, user.tt, receivedMAC) {
+		user.Close()
+		return false, nil
+	}
+
+	role, err := TrafficRole(user.Role, user.Suite.EncodePoint(user.Pa), user.Suite.EncodePoint(user.Pb))
+	if err != nil {
+		return false, err
+	}
+	session, err := NewSession(user.Suite, role, user.Identity, user.OpponentIdentity, user.sessionPrivateKey)
 	if err != nil {
 		return false, err
 	}
 
-	return user.Suite.MAC([]byte(receivedKey), user.ExpectedConfirmationKey, []byte(user.TT)), nil
-
+	user.session = session
+	user.state = StateConfirmed
+	return true, nil
 }
 
-// ProduceMacMessage creates Mac message based on RFC-9382 defiend format
-func (user *Participant) ProduceMacMessage() []byte {
-
-	msg, err := user.Encrypt(user.SessionConfirmationKey)
-	if err != nil {
-		return nil
+// ProduceMacMessage creates the RFC 9382 confirmation message MAC(Kc_self, TT)
+func (user *Participant) ProduceMacMessage() ([]byte, error) {
+	if err := user.expectState("ProduceMacMessage", StateKeyDerived, StateConfirmed); err != nil {
+		return nil, err
 	}
 
-	return msg
+	return user.Suite.Tag(user.sessionConfirmationKey, user.tt)
+}
+
+// Close ends the handshake and overwrites every secret, no further method can be used afterwards.
+// The Session handed out by Finish lives on until its own Close.
+func (user *Participant) Close() {
+	suite.WipeInt(user.x)
+	suite.WipeInt(user.w)
+	user.wm.Wipe()
+	user.wn.Wipe()
+	user.k.Wipe()
+	suite.Wipe(user.tt)
+	suite.Wipe(user.hashedTT)
+	suite.Wipe(user.sessionConfirmationKey)
+	suite.Wipe(user.expectedConfirmationKey)
+	suite.Wipe(user.sessionPrivateKey)
+
+	user.x, user.w, user.wm, user.wn, user.k = nil, nil, nil, nil, nil
+	user.tt, user.hashedTT, user.sessionConfirmationKey, user.expectedConfirmationKey, user.sessionPrivateKey = nil, nil, nil, nil, nil
+	user.session = nil
+	user.state = StateClosed
 }
 
 func Encode(b []byte) []byte {
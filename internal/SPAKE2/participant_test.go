@@ -0,0 +1,415 @@
+package spake2_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// vector holds one RFC 9382 Appendix B test vector, every value is hex encoded
+type vector struct {
+	Suite suite.SuiteOptions
+	A, B  string // identities of A (server) and B (client)
+	W     string
+	X     string // empty when the vector doesn't give x, only B is driven then
+	PA    string
+	Y     string
+	PB    string
+	K     string
+	TT    string
+	Ke    string
+	Ka    string
+	KcA   string
+	KcB   string
+	MACA  string // MAC(KcA, TT) sent by A
+	MACB  string // MAC(KcB, TT) sent by B
+}
+
+// vectors from RFC 9382 Appendix B, SPAKE2-P256-SHA256-HKDF-HMAC, with both identities, without A's,
+// without B's and without either
+var vectors = []vector{
+	{
+		Suite: suite.P256,
+		A:     "server",
+		B:     "client",
+		W:     "2ee57912099d31560b3a44b1184b9b4866e904c49d12ac5042c97dca461b1a5f",
+		X:     "43dd0fd7215bdcb482879fca3220c6a968e66d70b1356cac18bb26c84a78d729",
+		PA:    "04a56fa807caaa53a4d28dbb9853b9815c61a411118a6fe516a8798434751470f9010153ac33d0d5f2047ffdb1a3e42c9b4e6be662766e1eeb4116988ede5f912c",
+		Y:     "dcb60106f276b02606d8ef0a328c02e4b629f84f89786af5befb0bc75b6e66be",
+		PB:    "0406557e482bd03097ad0cbaa5df82115460d951e3451962f1eaf4367a420676d09857ccbc522686c83d1852abfa8ed6e4a1155cf8f1543ceca528afb591a1e0b7",
+		K:     "0412af7e89717850671913e6b469ace67bd90a4df8ce45c2af19010175e37eed69f75897996d539356e2fa6a406d528501f907e04d97515fbe83db277b715d3325",
+		TT:    "06000000000000007365727665720600000000000000636c69656e74410000000000000004a56fa807caaa53a4d28dbb9853b9815c61a411118a6fe516a8798434751470f9010153ac33d0d5f2047ffdb1a3e42c9b4e6be662766e1eeb4116988ede5f912c41000000000000000406557e482bd03097ad0cbaa5df82115460d951e3451962f1eaf4367a420676d09857ccbc522686c83d1852abfa8ed6e4a1155cf8f1543ceca528afb591a1e0b741000000000000000412af7e89717850671913e6b469ace67bd90a4df8ce45c2af19010175e37eed69f75897996d539356e2fa6a406d528501f907e04d97515fbe83db277b715d332520000000000000002ee57912099d31560b3a44b1184b9b4866e904c49d12ac5042c97dca461b1a5f",
+		Ke:    "0e0672dc86f8e45565d338b0540abe69",
+		Ka:    "15bdf72e2b35b5c9e5663168e960a91b",
+		KcA:   "00c12546835755c86d8c0db7851ae86f",
+		KcB:   "a9fa3406c3b781b93d804485430ca27a",
+		MACA:  "58ad4aa88e0b60d5061eb6b5dd93e80d9c4f00d127c65b3b35b1b5281fee38f0",
+		MACB:  "d3e2e547f1ae04f2dbdbf0fc4b79f8ecff2dff314b5d32fe9fcef2fb26dc459b",
+	},
+	{
+		Suite: suite.P256,
+		A:     "",
+		B:     "client",
+		W:     "0548d8729f730589e579b0475a582c1608138ddf7054b73b5381c7e883e2efae",
+		X:     "403abbe3b1b4b9ba17e3032849759d723939a27a27b9d921c500edde18ed654b",
+		PA:    "04a897b769e681c62ac1c2357319a3d363f610839c4477720d24cbe32f5fd85f44fb92ba966578c1b712be6962498834078262caa5b441ecfa9d4a9485720e918a",
+		Y:     "903023b6598908936ea7c929bd761af6039577a9c3f9581064187c3049d87065",
+		PB:    "04e0f816fd1c35e22065d5556215c097e799390d16661c386e0ecc84593974a61b881a8c82327687d0501862970c64565560cb5671f696048050ca66ca5f8cc7fc",
+		K:     "048f83ec9f6e4f87cc6f9dc740bdc2769725f923364f01c84148c049a39a735ebda82eac03e00112fd6a5710682767cff5361f7e819e53d8d3c3a2922e0d837aa6",
+		TT:    "00000000000000000600000000000000636c69656e74410000000000000004a897b769e681c62ac1c2357319a3d363f610839c4477720d24cbe32f5fd85f44fb92ba966578c1b712be6962498834078262caa5b441ecfa9d4a9485720e918a410000000000000004e0f816fd1c35e22065d5556215c097e799390d16661c386e0ecc84593974a61b881a8c82327687d0501862970c64565560cb5671f696048050ca66ca5f8cc7fc4100000000000000048f83ec9f6e4f87cc6f9dc740bdc2769725f923364f01c84148c049a39a735ebda82eac03e00112fd6a5710682767cff5361f7e819e53d8d3c3a2922e0d837aa620000000000000000548d8729f730589e579b0475a582c1608138ddf7054b73b5381c7e883e2efae",
+		Ke:    "642f05c473c2cd79909f9a841e2f30a7",
+		Ka:    "0bf89b18180af97353ba198789c2b963",
+		KcA:   "c6be376fc7cd1301fd0a13adf3e7bffd",
+		KcB:   "b7243f4ae60440a49b3f8cab3c1fba07",
+		MACA:  "47d29e6666af1b7dd450d571233085d7a9866e4d49d2645e2df975489521232b",
+		MACB:  "3313c5cefc361d27fb16847a91c2a73b766ffa90a4839122a9b70a2f6bd1d6df",
+	},
+	{
+		Suite: suite.P256,
+		A:     "server",
+		B:     "",
+		W:     "626e0cdc7b14c9db3e52a0b1b3a768c98e37852d5db30febe0497b14eae8c254",
+		PA:    "04f88fb71c99bfffaea370966b7eb99cd4be0ff1a7d335caac4211c4afd855e2e15a873b298503ad8ba1d9cbb9a392d2ba309b48bfd7879aefd0f2cea6009763b0",
+		Y:     "b6a4fc8dbb629d4ba51d6f91ed1532cf87adec98f25dd153a75accafafedec16",
+		PB:    "040c269d6be017dccb15182ac6bfcd9e2a14de019dd587eaf4bdfd353f031101e7cca177f8eb362a6e83e7d5e729c0732e1b528879c086f39ba0f31a9661bd34db",
+		K:     "0445ee233b8ecb51ebd6e7da3f307e88a1616bae2166121221fdc0dadb986afaf3ec8a988dc9c626fa3b99f58a7ca7c9b844bb3e8dd9554aafc5b53813504c1cbe",
+		TT:    "06000000000000007365727665720000000000000000410000000000000004f88fb71c99bfffaea370966b7eb99cd4be0ff1a7d335caac4211c4afd855e2e15a873b298503ad8ba1d9cbb9a392d2ba309b48bfd7879aefd0f2cea6009763b04100000000000000040c269d6be017dccb15182ac6bfcd9e2a14de019dd587eaf4bdfd353f031101e7cca177f8eb362a6e83e7d5e729c0732e1b528879c086f39ba0f31a9661bd34db41000000000000000445ee233b8ecb51ebd6e7da3f307e88a1616bae2166121221fdc0dadb986afaf3ec8a988dc9c626fa3b99f58a7ca7c9b844bb3e8dd9554aafc5b53813504c1cbe2000000000000000626e0cdc7b14c9db3e52a0b1b3a768c98e37852d5db30febe0497b14eae8c254",
+		Ke:    "005184ff460da2ce59062c87733c299c",
+		Ka:    "3521297d736598fc0a1127600efa1afb",
+		KcA:   "f3da53604f0aeecea5a33be7bddf6edf",
+		KcB:   "9e3f86848736f159bd92b6e107ec6799",
+		MACA:  "bc9f9bbe99f26d0b2260e6456e05a86196a3307ec6663a18bf6ac825736533b2",
+		MACB:  "c2370e1bf813b086dff0d834e74425a06e6390f48f5411900276dcccc5a297ec",
+	},
+	{
+		Suite: suite.P256,
+		A:     "",
+		B:     "",
+		W:     "7bf46c454b4c1b25799527d896508afd5fc62ef4ec59db1efb49113063d70cca",
+		X:     "8cef65df64bb2d0f83540c53632de911b5b24b3eab6cc74a97609fd659e95473",
+		PA:    "04a65b367a3f613cf9f0654b1b28a1e3a8a40387956c8ba6063e8658563890f46ca1ef6a676598889fc28de2950ab8120b79a5ef1ea4c9f44bc98f585634b46d66",
+		Y:     "d7a66f64074a84652d8d623a92e20c9675c61cb5b4f6a0063e4648a2fdc02d53",
+		PB:    "04589f13218822710d98d8b2123a079041052d9941b9cf88c6617ddb2fcc0494662eea8ba6b64692dc318250030c6af045cb738bc81ba35b043c3dcb46adf6f58d",
+		K:     "041a3c03d51b452537ca2a1fea6110353c6d5ed483c4f0f86f4492ca3f378d40a994b4477f93c64d928edbbcd3e85a7c709b7ea73ee97986ce3d1438e135543772",
+		TT:    "00000000000000000000000000000000410000000000000004a65b367a3f613cf9f0654b1b28a1e3a8a40387956c8ba6063e8658563890f46ca1ef6a676598889fc28de2950ab8120b79a5ef1ea4c9f44bc98f585634b46d66410000000000000004589f13218822710d98d8b2123a079041052d9941b9cf88c6617ddb2fcc0494662eea8ba6b64692dc318250030c6af045cb738bc81ba35b043c3dcb46adf6f58d4100000000000000041a3c03d51b452537ca2a1fea6110353c6d5ed483c4f0f86f4492ca3f378d40a994b4477f93c64d928edbbcd3e85a7c709b7ea73ee97986ce3d1438e13554377220000000000000007bf46c454b4c1b25799527d896508afd5fc62ef4ec59db1efb49113063d70cca",
+		Ke:    "fc6374762ba5cf11f4b2caa08b2cd1b9",
+		Ka:    "907ae0e26e8d6234318d91583cd74c86",
+		KcA:   "5dbd2f477166b7fb6d61febbd77a5563",
+		KcB:   "7689b4654407a5faeffdc8f18359d8a3",
+		MACA:  "dfb4db8d48ae5a675963ea5e6c19d98d4ea028d8e898dad96ea19a80ade95dca",
+		MACB:  "d0f0609d1613138d354f7e95f19fb556bf52d751947241e8c7118df5ef0ae175",
+	},
+}
+
+// expect compares got with the hex encoded want
+func expect(t *testing.T, name string, got []byte, want string) {
+	t.Helper()
+	if !bytes.Equal(got, paketest.MustHex(want)) {
+		t.Errorf("%s mismatch: got %x, want %s", name, got, want)
+	}
+}
+
+// TestVectors drives B (client) and, when the vector gives x, A (server) with the fixed scalars of
+// each vector. Each side takes the peer's share from the vector and is checked against it on its own.
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		t.Run(fmt.Sprintf("A=%q,B=%q", v.A, v.B), func(t *testing.T) {
+			sides := []struct {
+				name               string
+				role               suite.Role
+				identity, opponent string
+				scalar             string
+				share, peerShare   string
+				mac, peerMAC       string
+			}{
+				{"A", suite.Server, v.A, v.B, v.X, v.PA, v.PB, v.MACA, v.MACB},
+				{"B", suite.Client, v.B, v.A, v.Y, v.PB, v.PA, v.MACB, v.MACA},
+			}
+
+			for _, side := range sides {
+				if side.scalar == "" {
+					continue
+				}
+
+				// the scalar is fed through the randomness source so it comes out exactly as in the vector
+				user := &spake2.Participant{Role: side.role, Identity: side.identity}
+				if err := user.SetUp(&spake2.SetUpParams{
+					OpponentIdentity: side.opponent, Suite: v.Suite,
+					W: new(big.Int).SetBytes(paketest.MustHex(v.W)), Rand: bytes.NewReader(paketest.MustHex(side.scalar)),
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				share, err := user.ComputepPoint()
+				if err != nil {
+					t.Fatalf("computing p%s: %v", side.name, err)
+				}
+				expect(t, "p"+side.name, user.Suite.EncodePoint(share), side.share)
+
+				peerShare, err := user.Suite.DecodePoint(paketest.MustHex(side.peerShare))
+				if err != nil {
+					t.Fatal(err)
+				}
+				k, err := user.ComputepGroupElement(peerShare)
+				if err != nil {
+					t.Fatalf("computing K (%s): %v", side.name, err)
+				}
+				expect(t, "K ("+side.name+")", user.Suite.EncodePoint(k), v.K)
+
+				tt, err := user.ComputeTranscript()
+				if err != nil {
+					t.Fatalf("computing TT (%s): %v", side.name, err)
+				}
+				expect(t, "TT ("+side.name+")", tt, v.TT)
+
+				if err := user.DeriveKeys(); err != nil {
+					t.Fatalf("deriving keys (%s): %v", side.name, err)
+				}
+
+				// nothing derived is handed out before the peer's MAC is confirmed
+				if _, err := user.KeySchedule(); err == nil {
+					t.Fatal("key schedule available before confirmation")
+				}
+
+				mac, err := user.ProduceMacMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				expect(t, "MAC "+side.name, mac, side.mac)
+
+				if ok, err := user.ConfirmMAC(paketest.MustHex(side.peerMAC)); err != nil || !ok {
+					t.Fatalf("%s rejected the peer's MAC: %v", side.name, err)
+				}
+
+				ks, err := user.KeySchedule()
+				if err != nil {
+					t.Fatal(err)
+				}
+				expect(t, "Ka ("+side.name+")", ks.Ka, v.Ka)
+				expect(t, "KcA ("+side.name+")", ks.KcA, v.KcA)
+				expect(t, "KcB ("+side.name+")", ks.KcB, v.KcB)
+
+				ke, err := user.SessionKey()
+				if err != nil {
+					t.Fatal(err)
+				}
+				expect(t, "Ke ("+side.name+")", ke, v.Ke)
+			}
+		})
+	}
+}
+
+// TestStateOrder calls the steps of a handshake out of order and checks each is refused with a
+// StateError, and that a wrong MAC closes the participant for good
+func TestStateOrder(t *testing.T) {
+	client := &spake2.Participant{Role: suite.Client, Identity: "client"}
+	if err := client.SetUp(&spake2.SetUpParams{Pw: "password", OpponentIdentity: "server", Suite: suite.P256}); err != nil {
+		t.Fatal(err)
+	}
+	server := &spake2.Participant{Role: suite.Server, Identity: "server"}
+	if err := server.SetUp(&spake2.SetUpParams{Pw: "password", OpponentIdentity: "client", Suite: suite.P256}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stateErr *spake2.StateError
+	if _, err := client.ComputeTranscript(); !errors.As(err, &stateErr) {
+		t.Fatalf("transcript before the shares: got %v, want a StateError", err)
+	}
+
+	if _, err := client.ComputepPoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ComputepPoint(); !errors.As(err, &stateErr) {
+		t.Fatalf("second share: got %v, want a StateError", err)
+	}
+	if _, err := client.ProduceMacMessage(); !errors.As(err, &stateErr) {
+		t.Fatalf("MAC before the keys: got %v, want a StateError", err)
+	}
+
+	serverShare, err := server.ComputepPoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ComputepGroupElement(serverShare); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeriveKeys(); !errors.As(err, &stateErr) {
+		t.Fatalf("keys before the transcript: got %v, want a StateError", err)
+	}
+	if _, err := client.ComputeTranscript(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeriveKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := client.ConfirmMAC(make([]byte, 32)); err != nil || ok {
+		t.Fatalf("wrong MAC: got %v, %v, want it rejected", ok, err)
+	}
+	if client.State() != spake2.StateClosed {
+		t.Fatalf("state %v after a wrong MAC, want %v", client.State(), spake2.StateClosed)
+	}
+	if _, err := client.KeySchedule(); !errors.As(err, &stateErr) {
+		t.Fatalf("key schedule after a wrong MAC: got %v, want a StateError", err)
+	}
+}
+
+// TestHandshake runs a client and a server through Start, Respond and Finish and checks their sessions
+// agree, with a wrong password the confirmation has to fail
+func TestHandshake(t *testing.T) {
+	for _, serverPw := range []string{"password", "wrong password"} {
+		t.Run(serverPw, func(t *testing.T) {
+			client, err := spake2.NewParticipant(suite.Client, "client", &spake2.SetUpParams{Pw: "password", OpponentIdentity: "server", Suite: suite.P256})
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := spake2.NewParticipant(suite.Server, "server", &spake2.SetUpParams{Pw: serverPw, OpponentIdentity: "client", Suite: suite.P256})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clientSession, serverSession, err := paketest.RunHandshake(client, server)
+			if serverPw != "password" {
+				if !errors.Is(err, spake2.ErrConfirmationFailed) {
+					t.Fatalf("wrong password: got %v, want %v", err, spake2.ErrConfirmationFailed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !paketest.SessionsAgree(clientSession, serverSession) || !paketest.SessionsAgree(serverSession, clientSession) {
+				t.Fatal("sessions don't agree")
+			}
+		})
+	}
+}
+
+// TestSymmetric runs two symmetric peers that start simultaneously and checks they derive the same key
+// and can talk over the session. Only the shares order the peers, identities may be equal or empty.
+func TestSymmetric(t *testing.T) {
+	for _, ids := range [][2]string{{"alice", "bob"}, {"peer", "peer"}, {"", ""}} {
+		t.Run(ids[0]+"/"+ids[1], func(t *testing.T) {
+			peers := make([]*spake2.Participant, 2)
+			for i, id := range ids {
+				p, err := spake2.NewParticipant(suite.Symmetric, id, &spake2.SetUpParams{
+					Pw:               "password",
+					OpponentIdentity: ids[1-i],
+					Suite:            suite.P256,
+					Rand:             spake2.NewDeterministicReader([]byte{byte(i)}),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				peers[i] = p
+			}
+
+			shares := make([][]byte, 2)
+			for i, p := range peers {
+				share, err := p.Start()
+				if err != nil {
+					t.Fatal(err)
+				}
+				shares[i] = share
+			}
+
+			macs := make([][]byte, 2)
+			for i, p := range peers {
+				mac, err := p.Respond(shares[1-i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				macs[i] = mac
+			}
+
+			schedules := make([]*spake2.KeySchedule, 2)
+			sessions := make([]*spake2.Session, 2)
+			for i, p := range peers {
+				session, err := p.Finish(macs[1-i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				sessions[i] = session
+
+				ks, err := p.KeySchedule()
+				if err != nil {
+					t.Fatal(err)
+				}
+				schedules[i] = ks
+			}
+
+			if !bytes.Equal(schedules[0].TT, schedules[1].TT) {
+				t.Fatal("peers built different transcripts")
+			}
+			if !bytes.Equal(schedules[0].Ke, schedules[1].Ke) {
+				t.Fatal("peers derived different keys")
+			}
+			if !paketest.SessionsAgree(sessions[0], sessions[1]) || !paketest.SessionsAgree(sessions[1], sessions[0]) {
+				t.Fatal("peers can't open each other's records")
+			}
+
+			// Close wipes the secrets, nothing derived from them is handed out afterwards
+			peers[0].Close()
+			if _, err := peers[0].KeySchedule(); err == nil {
+				t.Fatal("key schedule still available after Close")
+			}
+		})
+	}
+}
+
+// TestCMACConfirmation runs a handshake on the P256-CMAC suite, whose confirmation messages carry 16 byte
+// CMAC-AES-128 tags, and checks a tampered tag is rejected
+func TestCMACConfirmation(t *testing.T) {
+	for _, tamper := range []bool{false, true} {
+		client, err := spake2.NewParticipant(suite.Client, "client", &spake2.SetUpParams{
+			Pw: "password", OpponentIdentity: "server", Suite: suite.P256CMAC, Rand: spake2.NewDeterministicReader([]byte("client")),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, err := spake2.NewParticipant(suite.Server, "server", &spake2.SetUpParams{
+			Pw: "password", OpponentIdentity: "client", Suite: suite.P256CMAC, Rand: spake2.NewDeterministicReader([]byte("server")),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clientShare, err := client.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverShare, err := server.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientConfirm, err := client.Respond(serverShare)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Respond(clientShare); err != nil {
+			t.Fatal(err)
+		}
+
+		// message type || 16 byte tag
+		if len(clientConfirm) != 1+16 {
+			t.Fatalf("confirmation message of %d bytes, want a 16 byte CMAC tag", len(clientConfirm))
+		}
+		if tamper {
+			clientConfirm[len(clientConfirm)-1] ^= 1
+		}
+
+		_, err = server.Finish(clientConfirm)
+		if tamper && !errors.Is(err, spake2.ErrConfirmationFailed) {
+			t.Fatalf("tampered tag: got %v, want %v", err, spake2.ErrConfirmationFailed)
+		}
+		if !tamper && err != nil {
+			t.Fatal(err)
+		}
+	}
+}
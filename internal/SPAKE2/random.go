@@ -0,0 +1,33 @@
+package spake2
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"io"
+)
+
+// NewDeterministicReader returns a reader producing the same byte stream for the same seed.
+// It is meant for tests, handshake replay and vector generation, never for production handshakes.
+func NewDeterministicReader(seed []byte) io.Reader {
+	key := sha256.Sum256(seed)
+
+	// AES-256 in counter mode over an endless stream of zeros
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+
+	stream := cipher.NewCTR(block, make([]byte, aes.BlockSize))
+	return cipher.StreamReader{S: stream, R: zeroReader{}}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,79 @@
+package spake2
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ReplayWindow is how many records behind the newest one can still be accepted, out of order
+const ReplayWindow = 64
+
+var (
+	// ErrReplayedRecord is returned by Open for a record whose sequence number was already accepted
+	ErrReplayedRecord = errors.New("spake2: replayed record")
+
+	// ErrStaleRecord is returned by Open for a record too far behind the newest one to be checked
+	ErrStaleRecord = errors.New("spake2: record outside the replay window")
+
+	// ErrSequenceExhausted is returned by Seal once every sequence number has been used, the session must be replaced
+	ErrSequenceExhausted = errors.New("spake2: sequence numbers exhausted")
+)
+
+// seqLen is the size of the sequence number in front of every record
+const seqLen = 8
+
+// replayWindow remembers which of the last ReplayWindow sequence numbers were accepted
+type replayWindow struct {
+	started bool   // whether any record was accepted yet
+	highest uint64 // newest accepted sequence number
+	seen    uint64 // bit i is set when highest - i was accepted
+}
+
+// check tells whether seq may be accepted, without marking it
+func (w *replayWindow) check(seq uint64) error {
+	if !w.started || seq > w.highest {
+		return nil
+	}
+
+	behind := w.highest - seq
+	if behind >= ReplayWindow {
+		return ErrStaleRecord
+	}
+	if w.seen&(1<<behind) != 0 {
+		return ErrReplayedRecord
+	}
+
+	return nil
+}
+
+// mark records seq as accepted, only call it once the record authenticated
+func (w *replayWindow) mark(seq uint64) {
+	switch {
+	case !w.started:
+		w.started, w.highest, w.seen = true, seq, 1
+	case seq > w.highest:
+		shift := seq - w.highest
+		if shift >= ReplayWindow {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.highest = seq
+		w.seen |= 1
+	default:
+		w.seen |= 1 << (w.highest - seq)
+	}
+}
+
+// recordNonce binds the sequence number into the nonce: iv ^ (zero padding || seq)
+func recordNonce(iv []byte, seq uint64) []byte {
+	nonce := append([]byte{}, iv...)
+	var s [seqLen]byte
+	binary.BigEndian.PutUint64(s[:], seq)
+
+	for i := range s {
+		nonce[len(nonce)-seqLen+i] ^= s[i]
+	}
+
+	return nonce
+}
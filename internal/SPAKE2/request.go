@@ -1,21 +1,27 @@
 package spake2
 
 import (
-	"math/big"
-
 	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
 )
 
 type SPAKE2HelloRequest struct {
 	Identity string
 	Suite    suite.SuiteOptions
-	Prime    *big.Int
 }
 
 type SPAKE2PublickeyRequest struct {
-	PubliCKey *suite.Point
+	SessionID string // issued by the hello
+	Message   []byte // output of the client's Handshake.Start
+	State     []byte // state token of the previous response, plain SPAKE2 only
 }
 
 type SPAKE2MACRequest struct {
-	MACMessage []byte
+	SessionID string // issued by the hello
+	Message   []byte // output of the client's Handshake.Respond
+	State     []byte // state token of the previous response, plain SPAKE2 only
+}
+
+type SPAKE2MessageRequest struct {
+	SessionID string // issued by the hello of the handshake that established the session
+	Message   []byte // sealed with the session, the client's identity as aad
 }
@@ -1,25 +1,25 @@
 package spake2
 
-import (
-	"math/big"
-
-	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
-)
-
 type ErrorResponse struct {
 	Message string
 }
 
 type SPAKE2HelloResponse struct {
-	Identity string
-	Suite    string
-	Prime    *big.Int
+	Identity  string
+	Suite     string
+	SessionID string // names the session in every later request
+	State     []byte // sealed handshake state, sent back with the next request
 }
 
 type SPAKE2PublicKeyResponse struct {
-	PublicKey *suite.Point
+	Message []byte // output of the server's Handshake.Start
+	State   []byte // sealed handshake state, sent back with the next request
 }
 
 type SPAKE2MACResponse struct {
-	MACMessage []byte
+	Message []byte // output of the server's Handshake.Respond, only sent once the client is confirmed
+}
+
+type SPAKE2MessageResponse struct {
+	Message []byte // the server's reply, sealed the same way
 }
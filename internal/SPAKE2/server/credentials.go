@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	spake2plus "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2plus"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+var (
+	// ErrUnknownIdentity is returned by a CredentialStore for an identity it has no credential for
+	ErrUnknownIdentity = errors.New("server: unknown identity")
+
+	// ErrAlreadyEnrolled is returned by Enroll for an identity that already has a credential
+	ErrAlreadyEnrolled = errors.New("server: identity already enrolled")
+)
+
+// Credential is what the server keeps for a client, values derived from the password but never
+// the password itself. Derive them with DeriveCredential.
+type Credential struct {
+	Suite suite.SuiteOptions // the suite W and Verifier are for, hellos asking for another are refused
+
+	// W is the SPAKE2 w of the password. CPace, J-PAKE and Dragonfly take it in place of the password,
+	// clients compute it with PasswordSecret. Like the password it lets anyone log in as the client.
+	W []byte
+
+	// Verifier is the SPAKE2+ registration record, it doesn't let anyone log in as the client
+	Verifier *spake2plus.Record
+}
+
+// CredentialStore keeps the credentials of the clients a server knows. Implementations are safe
+// for concurrent use and hand out copies, changing a returned Credential changes nothing stored.
+type CredentialStore interface {
+	// Lookup returns the credential of identity, ErrUnknownIdentity when there is none
+	Lookup(identity string) (*Credential, error)
+
+	// Enroll adds the credential of a new identity, ErrAlreadyEnrolled when it has one
+	Enroll(identity string, cred *Credential) error
+
+	// Update replaces the credential of identity, like after a password change
+	Update(identity string, cred *Credential) error
+
+	// Delete forgets identity, it can't log in anymore
+	Delete(identity string) error
+}
+
+// PasswordSecret is W of a password: the encoded SPAKE2 w for the suite
+func PasswordSecret(s *suite.Suite, pw string) []byte {
+	w := spake2.DeriveW(s, pw)
+	defer suite.WipeInt(w)
+
+	return s.EncodeScalar(w)
+}
+
+// DeriveCredential computes what a server identified as serverIdentity keeps for identity, the
+// SPAKE2+ record gets a fresh salt
+func DeriveCredential(suiteName suite.SuiteOptions, pw, identity, serverIdentity string) (*Credential, error) {
+	s := suite.SelectECCSuite(suiteName)
+	if s == nil {
+		return nil, fmt.Errorf("server: unknown suite %q", suiteName)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	secrets, err := spake2plus.DeriveSecrets(s, pw, identity, serverIdentity, salt)
+	if err != nil {
+		return nil, err
+	}
+	defer suite.WipeInt(secrets.W0)
+	defer suite.WipeInt(secrets.W1)
+
+	return &Credential{
+		Suite:    suiteName,
+		W:        PasswordSecret(s, pw),
+		Verifier: secrets.Record(s, salt),
+	}, nil
+}
+
+// clone returns a deep copy
+func (c *Credential) clone() *Credential {
+	out := &Credential{Suite: c.Suite, W: append([]byte{}, c.W...)}
+	if c.Verifier != nil {
+		out.Verifier = &spake2plus.Record{
+			W0:   append([]byte{}, c.Verifier.W0...),
+			L:    append([]byte{}, c.Verifier.L...),
+			Salt: append([]byte{}, c.Verifier.Salt...),
+		}
+	}
+
+	return out
+}
+
+// wipe overwrites the password equivalent parts of the credential
+func (c *Credential) wipe() {
+	suite.Wipe(c.W)
+	if c.Verifier != nil {
+		suite.Wipe(c.Verifier.W0)
+	}
+}
+
+// MemoryCredentials is a CredentialStore that only lives as long as the process
+type MemoryCredentials struct {
+	mu          sync.RWMutex
+	credentials map[string]*Credential
+}
+
+// NewMemoryCredentials returns an empty in memory store
+func NewMemoryCredentials() *MemoryCredentials {
+	return &MemoryCredentials{credentials: make(map[string]*Credential)}
+}
+
+// Lookup returns a copy of the credential of identity
+func (m *MemoryCredentials) Lookup(identity string) (*Credential, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cred, ok := m.credentials[identity]
+	if !ok {
+		return nil, ErrUnknownIdentity
+	}
+
+	return cred.clone(), nil
+}
+
+// Enroll keeps a copy of the credential of a new identity
+func (m *MemoryCredentials) Enroll(identity string, cred *Credential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.credentials[identity]; ok {
+		return ErrAlreadyEnrolled
+	}
+
+	m.credentials[identity] = cred.clone()
+	return nil
+}
+
+// Update replaces the credential of identity and wipes the old one
+func (m *MemoryCredentials) Update(identity string, cred *Credential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.credentials[identity]
+	if !ok {
+		return ErrUnknownIdentity
+	}
+
+	old.wipe()
+	m.credentials[identity] = cred.clone()
+	return nil
+}
+
+// Delete forgets identity and wipes its credential
+func (m *MemoryCredentials) Delete(identity string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.credentials[identity]
+	if !ok {
+		return ErrUnknownIdentity
+	}
+
+	old.wipe()
+	delete(m.credentials, identity)
+	return nil
+}
+
+var _ CredentialStore = (*MemoryCredentials)(nil)
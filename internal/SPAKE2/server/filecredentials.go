@@ -0,0 +1,273 @@
+package server
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// CredentialsKeySize is the size of the key a credentials file is encrypted with
+	CredentialsKeySize = chacha20poly1305.KeySize
+
+	// credentialsAAD binds the file to its purpose, a state token key can't open it by mistake
+	credentialsAAD = "SPAKE2-playground credentials v1"
+)
+
+// FileCredentials is a CredentialStore kept in a JSON file encrypted with XChaCha20-Poly1305:
+// nonce || AEAD(JSON). Every change goes to a temporary file that then replaces the old one, so
+// the file is never half written, and a file changed by someone else is read again on the next call.
+// Changes made from two processes at once can overwrite each other, one of them should own the file.
+type FileCredentials struct {
+	path        string
+	aead        cipher.AEAD
+	mu          sync.Mutex
+	credentials map[string]*Credential
+	loaded      fileVersion // version of the file credentials was read from or written to
+}
+
+// fileVersion tells whether the file changed since we read it. A write renames another file over it,
+// so the file itself changes, and an edit in place changes its modification time or size.
+type fileVersion struct {
+	info os.FileInfo // nil when nothing was read
+}
+
+// is tells whether info describes the file of the version, unchanged
+func (v fileVersion) is(info os.FileInfo) bool {
+	return v.info != nil && os.SameFile(v.info, info) && v.info.ModTime().Equal(info.ModTime()) && v.info.Size() == info.Size()
+}
+
+// OpenFileCredentials reads the credentials in path, a missing file is an empty store that is
+// created on the first change
+func OpenFileCredentials(path string, key []byte) (*FileCredentials, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("server: credentials key: %w", err)
+	}
+
+	f := &FileCredentials{path: path, aead: aead}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Lookup returns a copy of the credential of identity, from the file as it is now
+func (f *FileCredentials) Lookup(identity string) (*Credential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	cred, ok := f.credentials[identity]
+	if !ok {
+		return nil, ErrUnknownIdentity
+	}
+
+	return cred.clone(), nil
+}
+
+// Enroll adds the credential of a new identity to the file
+func (f *FileCredentials) Enroll(identity string, cred *Credential) error {
+	return f.change(func() error {
+		if _, ok := f.credentials[identity]; ok {
+			return ErrAlreadyEnrolled
+		}
+
+		f.credentials[identity] = cred.clone()
+		return nil
+	})
+}
+
+// Update replaces the credential of identity in the file
+func (f *FileCredentials) Update(identity string, cred *Credential) error {
+	return f.change(func() error {
+		old, ok := f.credentials[identity]
+		if !ok {
+			return ErrUnknownIdentity
+		}
+
+		old.wipe()
+		f.credentials[identity] = cred.clone()
+		return nil
+	})
+}
+
+// Delete removes identity from the file
+func (f *FileCredentials) Delete(identity string) error {
+	return f.change(func() error {
+		old, ok := f.credentials[identity]
+		if !ok {
+			return ErrUnknownIdentity
+		}
+
+		old.wipe()
+		delete(f.credentials, identity)
+		return nil
+	})
+}
+
+// change applies fn to the credentials as the file has them now and writes the result back.
+// When the write fails the file is read again on the next call, what we hold never drifts from it.
+func (f *FileCredentials) change(fn func() error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.reload(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+
+	if err := f.write(); err != nil {
+		f.loaded = fileVersion{}
+		return err
+	}
+
+	return nil
+}
+
+// reload reads the file again if it changed since we last read or wrote it, f.mu must be held
+func (f *FileCredentials) reload() error {
+	file, err := os.Open(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		f.replace(make(map[string]*Credential), fileVersion{})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the version and the content come from the same open file, a rename in between can't mix them up
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if f.credentials != nil && f.loaded.is(info) {
+		return nil
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := f.decrypt(data)
+	if err != nil {
+		return err
+	}
+
+	f.replace(credentials, fileVersion{info: info})
+	return nil
+}
+
+// replace swaps in credentials read from the file and wipes the ones they replace
+func (f *FileCredentials) replace(credentials map[string]*Credential, version fileVersion) {
+	for _, cred := range f.credentials {
+		cred.wipe()
+	}
+
+	f.credentials = credentials
+	f.loaded = version
+}
+
+func (f *FileCredentials) decrypt(data []byte) (map[string]*Credential, error) {
+	if len(data) < f.aead.NonceSize()+f.aead.Overhead() {
+		return nil, fmt.Errorf("server: credentials file %s is truncated", f.path)
+	}
+
+	nonce := data[:f.aead.NonceSize()]
+	plaintext, err := f.aead.Open(nil, nonce, data[len(nonce):], []byte(credentialsAAD))
+	if err != nil {
+		return nil, fmt.Errorf("server: credentials file %s can't be opened with this key", f.path)
+	}
+	defer suite.Wipe(plaintext)
+
+	credentials := make(map[string]*Credential)
+	if err := json.Unmarshal(plaintext, &credentials); err != nil {
+		return nil, fmt.Errorf("server: credentials file %s: %w", f.path, err)
+	}
+
+	return credentials, nil
+}
+
+// write encrypts the credentials to a temporary file next to ours and renames it over ours, f.mu must be held
+func (f *FileCredentials) write() error {
+	plaintext, err := json.Marshal(f.credentials)
+	if err != nil {
+		return err
+	}
+	defer suite.Wipe(plaintext)
+
+	nonce := make([]byte, f.aead.NonceSize(), f.aead.NonceSize()+len(plaintext)+f.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := f.aead.Seal(nonce, nonce, plaintext, []byte(credentialsAAD))
+
+	// CreateTemp makes the file readable by us only
+	dir := filepath.Dir(f.path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(f.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	// the file renamed over ours is the one we wrote, not whatever f.path names once we look
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	syncDir(dir)
+
+	f.loaded = fileVersion{info: info}
+	return nil
+}
+
+// syncDir makes the rename durable where the platform allows syncing a directory, the file itself
+// is already synced so this is best effort
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	d.Sync()
+}
+
+var _ CredentialStore = (*FileCredentials)(nil)
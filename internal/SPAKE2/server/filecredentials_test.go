@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// TestFileCredentialsReloadsReplacedFile replaces the file with another of the same size and
+// modification time, like a copy that keeps times would, and checks the store reads it again
+func TestFileCredentialsReloadsReplacedFile(t *testing.T) {
+	dir := t.TempDir()
+	key := make([]byte, CredentialsKeySize)
+	path, other := filepath.Join(dir, "credentials"), filepath.Join(dir, "other")
+
+	open := func(path, identity string) *FileCredentials {
+		t.Helper()
+		f, err := OpenFileCredentials(path, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Enroll(identity, &Credential{Suite: suite.P256, W: []byte("w")}); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	f := open(path, "alice")
+	open(other, "carol")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(other, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Lookup("carol"); err != nil {
+		t.Fatalf("credential of the replacing file: %v", err)
+	}
+	if _, err := f.Lookup("alice"); !errors.Is(err, ErrUnknownIdentity) {
+		t.Fatalf("credential of the replaced file: got %v, want %v", err, ErrUnknownIdentity)
+	}
+}
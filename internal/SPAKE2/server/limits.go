@@ -0,0 +1,393 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Attempts is what an AttemptStore keeps per identity or source
+type Attempts struct {
+	Failures    int       // attempts that didn't succeed, an attempt counts as failed until it succeeds
+	Rejected    int       // attempts whose confirmation was checked and didn't match, only these lock out
+	LastFailure time.Time // when the last one started
+	Locked      bool      // too many rejections, no more attempts until LockedUntil or Unlock
+	LockedUntil time.Time // zero while Locked means until Unlock
+	Expires     time.Time // the record can be dropped after this, zero means never
+}
+
+// AttemptStore keeps the attempts of identities and sources, it can be shared by several servers so a
+// client can't spread its guesses over them. Implementations are safe for concurrent use.
+type AttemptStore interface {
+	// Load returns the record of key, the zero Attempts when there is none
+	Load(key string) (Attempts, error)
+
+	// Update applies fn to the record of key and stores the result, atomically with respect to other
+	// calls for the same key
+	Update(key string, fn func(*Attempts)) (Attempts, error)
+
+	// Delete forgets key
+	Delete(key string) error
+}
+
+// LimitPolicy is how failures of one identity or source are answered: the first FreeFailures go
+// unanswered, each further one doubles the wait before the next attempt starting from BackoffBase,
+// and Lockout rejected confirmations lock it out for LockoutDuration. Zero fields take the defaults.
+type LimitPolicy struct {
+	FreeFailures    int
+	BackoffBase     time.Duration
+	BackoffMax      time.Duration
+	Lockout         int           // negative never locks out, only the backoff applies
+	LockoutDuration time.Duration // negative locks until Unlock
+}
+
+// Limits slows down online password guessing. Every confirmation the server checks, where a guess at
+// the password is tested, counts against the client's identity and against its source address.
+// Anyone can send a wrong confirmation for any identity, so lockouts only last LockoutDuration
+// unless configured otherwise. Zero values take the defaults.
+type Limits struct {
+	Identity      LimitPolicy
+	Source        LimitPolicy   // looser than Identity, many clients can share an address
+	FailureWindow time.Duration // failures older than this are forgotten
+	Store         AttemptStore  // an in memory store when nil
+}
+
+var (
+	// DefaultIdentityPolicy is used for the fields of Limits.Identity left zero
+	DefaultIdentityPolicy = LimitPolicy{FreeFailures: 3, BackoffBase: time.Second, BackoffMax: 15 * time.Minute, Lockout: 20, LockoutDuration: time.Hour}
+
+	// DefaultSourcePolicy is used for the fields of Limits.Source left zero
+	DefaultSourcePolicy = LimitPolicy{FreeFailures: 20, BackoffBase: time.Second, BackoffMax: 15 * time.Minute, Lockout: 200, LockoutDuration: time.Hour}
+)
+
+// DefaultFailureWindow is how long failures are remembered unless configured otherwise
+const DefaultFailureWindow = 24 * time.Hour
+
+// blockedError is returned for an attempt refused because of earlier failures
+type blockedError struct {
+	what       string        // identity or source that is blocked
+	retryAfter time.Duration // zero when locked until Unlock
+	locked     bool
+}
+
+func (e *blockedError) Error() string {
+	if e.locked {
+		return fmt.Sprintf("%s is locked out after too many failed attempts", e.what)
+	}
+
+	return fmt.Sprintf("too many failed attempts for %s, retry in %s", e.what, e.retryAfter.Round(time.Second))
+}
+
+// limiter applies Limits to the attempts of a server
+type limiter struct {
+	identity LimitPolicy
+	source   LimitPolicy
+	window   time.Duration
+	store    AttemptStore
+}
+
+func newLimiter(limits Limits) *limiter {
+	l := &limiter{
+		identity: limits.Identity.withDefaults(DefaultIdentityPolicy),
+		source:   limits.Source.withDefaults(DefaultSourcePolicy),
+		window:   orDefault(limits.FailureWindow, DefaultFailureWindow),
+		store:    limits.Store,
+	}
+	if l.store == nil {
+		l.store = NewMemoryAttempts()
+	}
+
+	return l
+}
+
+func (p LimitPolicy) withDefaults(def LimitPolicy) LimitPolicy {
+	return LimitPolicy{
+		FreeFailures:    orDefault(p.FreeFailures, def.FreeFailures),
+		BackoffBase:     orDefault(p.BackoffBase, def.BackoffBase),
+		BackoffMax:      orDefault(p.BackoffMax, def.BackoffMax),
+		Lockout:         orDefault(p.Lockout, def.Lockout),
+		LockoutDuration: orDefault(p.LockoutDuration, def.LockoutDuration),
+	}
+}
+
+// delay is how long to wait after the last of failures before the next attempt
+func (p LimitPolicy) delay(failures int) time.Duration {
+	if failures < p.FreeFailures {
+		return 0
+	}
+
+	// past 2^32 seconds any sane BackoffMax is exceeded, stop shifting before it overflows
+	shift := failures - p.FreeFailures
+	if shift > 32 || p.BackoffBase > time.Duration(math.MaxInt64>>shift) {
+		return p.BackoffMax
+	}
+
+	return min(p.BackoffBase<<shift, p.BackoffMax)
+}
+
+// blocked returns why a was refused at now, nil when it can go ahead
+func (p LimitPolicy) blocked(what string, a *Attempts, now time.Time) *blockedError {
+	if a.Locked {
+		if a.LockedUntil.IsZero() {
+			return &blockedError{what: what, locked: true}
+		}
+		if now.Before(a.LockedUntil) {
+			return &blockedError{what: what, locked: true, retryAfter: a.LockedUntil.Sub(now)}
+		}
+	}
+
+	if next := a.LastFailure.Add(p.delay(a.Failures)); now.Before(next) {
+		return &blockedError{what: what, retryAfter: next.Sub(now)}
+	}
+
+	return nil
+}
+
+func identityKey(identity string) string { return "identity:" + identity }
+func sourceKey(source string) string     { return "source:" + source }
+
+// check refuses an attempt without counting one, to turn a blocked client away early
+func (l *limiter) check(identity, source string) error {
+	now := time.Now()
+	for _, k := range l.keys(identity, source) {
+		a, err := l.store.Load(k.key)
+		if err != nil {
+			return err
+		}
+		l.forget(&a, now)
+
+		if blocked := k.policy.blocked(k.what, &a, now); blocked != nil {
+			return blocked
+		}
+	}
+
+	return nil
+}
+
+// attemptKey is one of the two records an attempt counts against
+type attemptKey struct {
+	key, what string
+	policy    LimitPolicy
+}
+
+func (l *limiter) keys(identity, source string) []attemptKey {
+	return []attemptKey{
+		{sourceKey(source), source, l.source},
+		{identityKey(identity), identity, l.identity},
+	}
+}
+
+// begin counts an attempt against source and identity, once neither of them is blocked. Call it right
+// before the client's guess is tested and settle the attempt with failed or succeed. It counts as a
+// failure until succeed, a client that gives up halfway, like an OPAQUE client whose guess didn't open
+// the envelope, has used it up all the same.
+func (l *limiter) begin(identity, source string) error {
+	if err := l.check(identity, source); err != nil {
+		return err
+	}
+
+	// the check and the count are separate updates, a burst of concurrent attempts can get past the
+	// check together but each of them is counted
+	for _, k := range l.keys(identity, source) {
+		_, err := l.store.Update(k.key, func(a *Attempts) {
+			now := time.Now()
+			l.forget(a, now)
+
+			a.Failures++
+			a.LastFailure = now
+			l.setExpiry(a)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// failed settles an attempt whose confirmation didn't match, enough of them lock out
+func (l *limiter) failed(identity, source string) error {
+	for _, k := range l.keys(identity, source) {
+		_, err := l.store.Update(k.key, func(a *Attempts) {
+			now := time.Now()
+			l.forget(a, now)
+
+			a.Rejected++
+			if k.policy.Lockout > 0 && a.Rejected >= k.policy.Lockout && !a.Locked {
+				a.Locked = true
+				if k.policy.LockoutDuration > 0 {
+					a.LockedUntil = now.Add(k.policy.LockoutDuration)
+				}
+			}
+			l.setExpiry(a)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// succeed settles an attempt whose confirmation matched by taking back the failure begin counted for
+// it. Earlier failures and a lockout that started meanwhile stay: a handshake started before the
+// lockout doesn't lift it, and a client with an account of its own can't clear its guesses at others.
+func (l *limiter) succeed(identity, source string) error {
+	for _, k := range l.keys(identity, source) {
+		_, err := l.store.Update(k.key, func(a *Attempts) {
+			l.forget(a, time.Now())
+			if a.Failures > 0 {
+				a.Failures--
+			}
+			if a.Failures == 0 && a.Rejected == 0 && !a.Locked {
+				*a = Attempts{}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retryAfter is how long identity and source have to wait now, zero when they don't
+func (l *limiter) retryAfter(identity, source string) time.Duration {
+	var blocked *blockedError
+	if err := l.check(identity, source); errors.As(err, &blocked) {
+		return blocked.retryAfter
+	}
+
+	return 0
+}
+
+// forget drops failures older than the window and a lockout that ran out
+func (l *limiter) forget(a *Attempts, now time.Time) {
+	if a.Locked && !a.LockedUntil.IsZero() && !now.Before(a.LockedUntil) {
+		*a = Attempts{}
+	}
+	if !a.Locked && now.Sub(a.LastFailure) > l.window {
+		*a = Attempts{}
+	}
+}
+
+// setExpiry lets the store drop a once nothing in it matters anymore
+func (l *limiter) setExpiry(a *Attempts) {
+	switch {
+	case a.Locked && a.LockedUntil.IsZero():
+		a.Expires = time.Time{}
+	case a.Locked:
+		a.Expires = a.LockedUntil
+	default:
+		a.Expires = a.LastFailure.Add(l.window)
+	}
+}
+
+// Unlock clears the failures of identity, it can try again right away
+func (s *Server) Unlock(identity string) error {
+	return s.limits.store.Delete(identityKey(identity))
+}
+
+// UnlockSource clears the failures of a source address
+func (s *Server) UnlockSource(source string) error {
+	return s.limits.store.Delete(sourceKey(source))
+}
+
+// source is the address a request comes from. RemoteAddr is the peer of the connection: behind a
+// proxy every client shares the proxy's, configure the source policy accordingly.
+func source(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// limitError answers an attempt the limiter refused, with Retry-After when there is a time to retry at
+func limitError(w http.ResponseWriter, err error) {
+	var blocked *blockedError
+	if !errors.As(err, &blocked) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if blocked.retryAfter > 0 {
+		setRetryAfter(w, blocked.retryAfter)
+	}
+	if blocked.locked {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
+
+// setRetryAfter sets the header in whole seconds, rounded up so the client doesn't come back too early
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10))
+}
+
+// MemoryAttempts is an AttemptStore that only lives as long as the process
+type MemoryAttempts struct {
+	mu        sync.Mutex
+	attempts  map[string]Attempts
+	lastSweep time.Time
+}
+
+// attemptsSweepInterval is how often expired records are dropped
+const attemptsSweepInterval = time.Minute
+
+// NewMemoryAttempts returns an empty in memory store
+func NewMemoryAttempts() *MemoryAttempts {
+	return &MemoryAttempts{attempts: make(map[string]Attempts)}
+}
+
+// Load returns the record of key
+func (m *MemoryAttempts) Load(key string) (Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.attempts[key], nil
+}
+
+// Update applies fn to the record of key under the store's lock, a record left zero is dropped
+func (m *MemoryAttempts) Update(key string, fn func(*Attempts)) (Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > attemptsSweepInterval {
+		for k, a := range m.attempts {
+			if !a.Expires.IsZero() && now.After(a.Expires) {
+				delete(m.attempts, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	a := m.attempts[key]
+	fn(&a)
+	if a == (Attempts{}) {
+		delete(m.attempts, key)
+	} else {
+		m.attempts[key] = a
+	}
+
+	return a, nil
+}
+
+// Delete forgets key
+func (m *MemoryAttempts) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+var _ AttemptStore = (*MemoryAttempts)(nil)
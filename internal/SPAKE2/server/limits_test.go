@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/opaque"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// newTestLimiter returns a limiter without backoff, so only lockouts refuse attempts
+func newTestLimiter(lockout int, lockoutDuration time.Duration) *limiter {
+	policy := LimitPolicy{FreeFailures: 1 << 20, BackoffBase: time.Nanosecond, BackoffMax: time.Nanosecond, Lockout: lockout, LockoutDuration: lockoutDuration}
+	return newLimiter(Limits{Identity: policy, Source: policy})
+}
+
+func expectLocked(t *testing.T, err error, want bool) {
+	t.Helper()
+
+	var blocked *blockedError
+	if locked := errors.As(err, &blocked) && blocked.locked; locked != want {
+		t.Fatalf("locked: got %v (%v), want %v", locked, err, want)
+	}
+}
+
+// TestLimiterOnlyRejectionsLockOut checks attempts given up halfway slow a client down but never lock it
+// out, only confirmations that didn't match do
+func TestLimiterOnlyRejectionsLockOut(t *testing.T) {
+	l := newTestLimiter(3, time.Hour)
+
+	for i := 0; i < 10; i++ {
+		if err := l.begin("alice", "attacker"); err != nil {
+			t.Fatalf("attempt %d given up halfway: %v", i, err)
+		}
+	}
+	expectLocked(t, l.check("alice", "attacker"), false)
+
+	for i := 0; i < 3; i++ {
+		if err := l.begin("alice", "attacker"); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.failed("alice", "attacker"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLocked(t, l.check("alice", "attacker"), true)
+}
+
+// TestLimiterLockoutExpires checks the default lockout runs out by itself
+func TestLimiterLockoutExpires(t *testing.T) {
+	if DefaultIdentityPolicy.LockoutDuration <= 0 || DefaultSourcePolicy.LockoutDuration <= 0 {
+		t.Fatal("default lockouts last until Unlock")
+	}
+
+	l := newTestLimiter(1, 50*time.Millisecond)
+	if err := l.begin("alice", "attacker"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.failed("alice", "attacker"); err != nil {
+		t.Fatal(err)
+	}
+	expectLocked(t, l.check("alice", "attacker"), true)
+
+	time.Sleep(60 * time.Millisecond)
+	expectLocked(t, l.check("alice", "attacker"), false)
+}
+
+// TestLimiterBlockedIdentityCountsNothing checks an attempt refused because of its identity doesn't
+// count against its source either
+func TestLimiterBlockedIdentityCountsNothing(t *testing.T) {
+	l := newTestLimiter(1, time.Hour)
+	if err := l.begin("alice", "attacker"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.failed("alice", "attacker"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectLocked(t, l.begin("alice", "bystander"), true)
+
+	a, err := l.store.Load(sourceKey("bystander"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != (Attempts{}) {
+		t.Fatalf("refused attempt counted against its source: %+v", a)
+	}
+}
+
+// TestLimiterSuccessKeepsLockout checks a handshake started before a lockout and finishing after it
+// only takes back its own failure
+func TestLimiterSuccessKeepsLockout(t *testing.T) {
+	l := newTestLimiter(2, time.Hour)
+
+	// alice starts her login, meanwhile an attacker gets her locked out
+	if err := l.begin("alice", "alice's laptop"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.begin("alice", "attacker"); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.failed("alice", "attacker"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.succeed("alice", "alice's laptop"); err != nil {
+		t.Fatal(err)
+	}
+	expectLocked(t, l.check("alice", "alice's laptop"), true)
+
+	a, err := l.store.Load(identityKey("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Failures != 2 || a.Rejected != 2 {
+		t.Fatalf("got %d failures and %d rejections, want 2 and 2", a.Failures, a.Rejected)
+	}
+}
+
+// TestLimiterNegativeLockout checks a negative Lockout turns lockouts off without touching the backoff
+func TestLimiterNegativeLockout(t *testing.T) {
+	l := newTestLimiter(-1, time.Hour)
+
+	for i := 0; i < 100; i++ {
+		if err := l.begin("alice", "attacker"); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.failed("alice", "attacker"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLocked(t, l.check("alice", "attacker"), false)
+}
+
+// TestOPAQUEMalformedKE1CountsNothing sends KE1s the server can't answer and checks none of them counts
+// as an attempt, the client never got a KE2 to test a guess on
+func TestOPAQUEMalformedKE1CountsNothing(t *testing.T) {
+	s, err := New(&Options{Identity: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []opaque.LoginRequest{
+		{Identity: "alice", Suite: suite.P256, Message: []byte{1, 2, 3}},
+		{Identity: "alice", Suite: "no such suite", Message: []byte{1, 2, 3}},
+	}
+	for i := 0; i < 10; i++ {
+		for _, req := range requests {
+			body, err := json.Marshal(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/opaque/login", bytes.NewReader(body)))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("suite %q: got status %d, want %d", req.Suite, w.Code, http.StatusBadRequest)
+			}
+		}
+	}
+
+	for _, key := range []string{identityKey("alice"), sourceKey("192.0.2.1")} {
+		a, err := s.limits.store.Load(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != (Attempts{}) {
+			t.Fatalf("malformed KE1 counted against %s: %+v", key, a)
+		}
+	}
+}
@@ -1,42 +1,158 @@
 package server
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math/big"
 	"net/http"
+	"strings"
+	"time"
 
 	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	spake2plus "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2plus"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/cpace"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/dragonfly"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/jpake"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/opaque"
 	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
 )
 
+// Server answers the handshake endpoints of every protocol, it is an http.Handler
+// mounted wherever the application likes
 type Server struct {
-	clientMapping map[string]string
-	spake         spake2.Participant
-	httpClient    http.Client
+	identity    string
+	credentials CredentialStore     // what we know of each client, never its password
+	sessions    *sessionManager     // handshakes in progress and established sessions, per client
+	limits      *limiter            // failed attempts per identity and source
+	opaqueKeys  *opaque.ServerKeys  // long term OPAQUE keys, shared by every client
+	envelopes   *opaque.Store       // OPAQUE registration records
+	stateTokens *spake2.StateTokens // seal plain SPAKE2 handshakes between requests
+	mux         *http.ServeMux      // our endpoints only, nothing is registered globally
+	log         *log.Logger         // who logs in and what goes wrong, discarded unless configured
 }
 
-var (
-	clientPasswordMap = map[string]string{"Alice": "PythonISWAYBETTER"}
+// Options configures a Server, zero values take the defaults
+type Options struct {
+	Identity string // identity of the server in every handshake
+
+	// Credentials are the clients allowed to log in, an empty in memory store when nil
+	Credentials CredentialStore
+
+	// Prefix is prepended to every endpoint path, "/spake2" serves "/spake2/hello" and so on.
+	// The Server can then be mounted on the application's router under Prefix + "/".
+	Prefix string
+
+	// StateKey seals plain SPAKE2 handshake state, servers given the same key can continue each
+	// other's handshakes. A random key is used when nil, see SetStateKey.
+	StateKey []byte
+
+	HandshakeTTL   time.Duration // how long a client has for each step of a handshake
+	SessionIdleTTL time.Duration // how long an established session is kept without being used
+	MaxSessions    int           // cap on the sessions kept at once, half-open or established
+
+	// Limits slow down clients that fail to log in, so passwords can't be guessed as fast as we answer
+	Limits Limits
+
+	// Logger gets the clients that start a handshake or register and the errors no client is told
+	// about, nothing is logged when nil. Passwords, keys and messages are never logged.
+	Logger *log.Logger
+}
+
+const (
+	// spake2PlusContext is bound into every SPAKE2+ transcript of this server
+	spake2PlusContext = "SPAKE2-playground SPAKE2+ v1"
+
+	// opaqueContext is bound into every OPAQUE login of this server
+	opaqueContext = "SPAKE2-playground OPAQUE v1"
+
+	// DefaultHandshakeTTL is how long a client has for each step of a handshake unless configured otherwise
+	DefaultHandshakeTTL = 2 * time.Minute
+
+	// DefaultSessionIdleTTL is how long an idle session is kept unless configured otherwise
+	DefaultSessionIdleTTL = 30 * time.Minute
+
+	// DefaultMaxSessions is the cap on sessions unless configured otherwise
+	DefaultMaxSessions = 4096
 )
 
 // TODO: create handler functions that will automatically proceed the SPAKE2 process
 
-// Init function populates a new server instance
-func (s *Server) Init(identity string) (err error) {
-	s.spake.Role = suite.Server
-	s.spake.Identity = identity
-	s.clientMapping, err = s.getClienMapping()
+// New returns a server with its endpoints under opts.Prefix. Several servers can live in one process,
+// each only answers the requests it is handed.
+func New(opts *Options) (s *Server, err error) {
+	s = &Server{identity: opts.Identity, credentials: opts.Credentials, log: opts.Logger}
+	if s.credentials == nil {
+		s.credentials = NewMemoryCredentials()
+	}
+	if s.log == nil {
+		s.log = log.New(io.Discard, "", 0)
+	}
+
+	// OPAQUE clients register themselves, the server never sees their password
+	s.opaqueKeys, err = opaque.GenerateServerKeys(suite.NewP256Suite(), nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	s.envelopes = opaque.NewStore()
+	s.sessions = newSessionManager(
+		orDefault(opts.HandshakeTTL, DefaultHandshakeTTL),
+		orDefault(opts.SessionIdleTTL, DefaultSessionIdleTTL),
+		orDefault(opts.MaxSessions, DefaultMaxSessions),
+	)
+	s.limits = newLimiter(opts.Limits)
+
+	// a key of our own unless replicas that continue each other's handshakes share one
+	key := opts.StateKey
+	if key == nil {
+		key = make([]byte, spake2.StateKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.SetStateKey(key); err != nil {
+		return nil, err
 	}
 
 	// Add the endpoints
-	s.addFeatures()
+	s.addFeatures(strings.TrimSuffix(opts.Prefix, "/"))
+
+	return s, nil
+}
+
+// orDefault returns v, or def when v is the zero value
+func orDefault[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+
+	return v
+}
+
+// ServeHTTP dispatches the request to the endpoint it is for
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// SetStateKey sets the key plain SPAKE2 handshake state is sealed with. Every server given the same key
+// can continue a handshake another one started, and handshakes survive a restart.
+func (s *Server) SetStateKey(key []byte) error {
+	tokens, err := spake2.NewStateTokens(key, s.sessions.handshakeTTL)
+	if err != nil {
+		return err
+	}
 
+	s.stateTokens = tokens
 	return nil
 }
 
-// HandleHello handles hello from client for SPAKE2
+// HandleHello handles hello from client for SPAKE2, the handshake state goes to the client as a token
+// instead of being kept here. Only the session ID is registered, so half-open handshakes count against
+// the cap on sessions and each token is only taken once.
 func (s *Server) HandleHello(w http.ResponseWriter, r *http.Request) {
 	// Decode the request body into the struct
 	var req spake2.SPAKE2HelloRequest
@@ -46,28 +162,274 @@ func (s *Server) HandleHello(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println("Received a SPAKE2 HELLO from:", req.Identity)
+	s.log.Println("Received a SPAKE2 HELLO from:", req.Identity)
 
-	pw := s.clientMapping[req.Identity]
-	if pw == "" {
-		http.Error(w, "UnRecognized Client Identity", http.StatusBadRequest)
+	// attempts are counted with the MAC
+	if err := s.limits.check(req.Identity, source(r)); err != nil {
+		limitError(w, err)
+		return
+	}
+
+	cred, ok := s.lookup(w, req.Identity, req.Suite)
+	if !ok {
 		return
 	}
+	defer cred.wipe()
 
 	setUpParam := &spake2.SetUpParams{
-		Pw:               pw,
 		OpponentIdentity: req.Identity,
-		Prime:            req.Prime,
 		Suite:            req.Suite,
+		W:                new(big.Int).SetBytes(cred.W),
 	}
+	defer suite.WipeInt(setUpParam.W)
 
-	s.spake.SetUp(setUpParam)
+	// every hello starts a fresh handshake
+	participant, err := spake2.NewParticipant(suite.Server, s.identity, setUpParam)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer participant.Close()
+
+	cs, err := s.sessions.start(req.Identity)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	defer s.sessions.release(cs)
+	cs.carried, cs.step, cs.source = true, participant.State(), source(r)
+
+	state, err := s.stateTokens.Seal(participant, []byte(cs.id))
+	if err != nil {
+		s.sessions.remove(cs)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// Create a response struct
 	res := spake2.SPAKE2HelloResponse{
-		Identity: s.spake.Identity,
-		Suite:    string(s.spake.Suite.Name),
-		Prime:    req.Prime,
+		Identity:  participant.Identity,
+		Suite:     string(participant.Suite.Name),
+		SessionID: cs.id,
+		State:     state,
+	}
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleSPAKE2PlusHello handles hello from client for SPAKE2+, the rest of the handshake goes through
+// the same endpoints as SPAKE2
+func (s *Server) HandleSPAKE2PlusHello(w http.ResponseWriter, r *http.Request) {
+	// Decode the request body into the struct
+	var req spake2plus.HelloRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.log.Println("Received a SPAKE2+ HELLO from:", req.Identity)
+
+	cred, ok := s.lookup(w, req.Identity, req.Suite)
+	if !ok {
+		return
+	}
+	defer cred.wipe()
+
+	record := cred.Verifier
+	if record == nil {
+		http.Error(w, "no SPAKE2+ verifier for "+req.Identity, http.StatusBadRequest)
+		return
+	}
+
+	verifier, err := spake2plus.NewVerifier(s.identity, record, &spake2plus.SetUpParams{
+		Suite:            req.Suite,
+		Context:          spake2PlusContext,
+		OpponentIdentity: req.Identity,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := s.startHandshake(verifier, req.Identity, source(r), true)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+
+	// Create a response struct
+	res := spake2plus.HelloResponse{
+		Identity:  s.identity,
+		Suite:     string(verifier.Suite.Name),
+		SessionID: id,
+		Context:   spake2PlusContext,
+		Salt:      record.Salt,
+	}
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleCPaceHello handles hello from client for CPace, the rest of the handshake goes through
+// the same endpoints as SPAKE2
+func (s *Server) HandleCPaceHello(w http.ResponseWriter, r *http.Request) {
+	// Decode the request body into the struct
+	var req cpace.HelloRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.log.Println("Received a CPace HELLO from:", req.Identity)
+
+	cred, ok := s.lookup(w, req.Identity, req.Suite)
+	if !ok {
+		return
+	}
+	defer cred.wipe()
+
+	// a fresh session identifier for every handshake
+	sid := make([]byte, 16)
+	if _, err := rand.Read(sid); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responder, err := cpace.NewParticipant(suite.Server, s.identity, &cpace.SetUpParams{
+		Suite:            req.Suite,
+		PRS:              cred.W,
+		CI:               cpace.ChannelIdentifier(req.Identity, s.identity),
+		SID:              sid,
+		OpponentIdentity: req.Identity,
+		AD:               []byte(s.identity),
+		PeerAD:           []byte(req.Identity),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := s.startHandshake(responder, req.Identity, source(r), true)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+
+	// Create a response struct
+	res := cpace.HelloResponse{
+		Identity:  s.identity,
+		Suite:     string(responder.Suite.Name),
+		SessionID: id,
+		SID:       sid,
+	}
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleJPAKEHello handles hello from client for J-PAKE. Round 1 goes through the share endpoint
+// and round 2 through the MAC endpoint, J-PAKE has no confirmation of its own.
+func (s *Server) HandleJPAKEHello(w http.ResponseWriter, r *http.Request) {
+	// Decode the request body into the struct
+	var req jpake.HelloRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.log.Println("Received a J-PAKE HELLO from:", req.Identity)
+
+	cred, ok := s.lookup(w, req.Identity, req.Suite)
+	if !ok {
+		return
+	}
+	defer cred.wipe()
+
+	participant, err := jpake.NewParticipant(suite.Server, s.identity, &jpake.SetUpParams{
+		Suite:            req.Suite,
+		Pw:               string(cred.W),
+		OpponentIdentity: req.Identity,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := s.startHandshake(participant, req.Identity, source(r), false)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+
+	// Create a response struct
+	res := jpake.HelloResponse{
+		Identity:  s.identity,
+		Suite:     string(participant.Suite.Name),
+		SessionID: id,
+	}
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleDragonflyHello handles hello from client for Dragonfly, the commit and confirm go through
+// the same endpoints as the SPAKE2 share and MAC
+func (s *Server) HandleDragonflyHello(w http.ResponseWriter, r *http.Request) {
+	// Decode the request body into the struct
+	var req dragonfly.HelloRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.log.Println("Received a Dragonfly HELLO from:", req.Identity)
+
+	cred, ok := s.lookup(w, req.Identity, req.Suite)
+	if !ok {
+		return
+	}
+	defer cred.wipe()
+
+	participant, err := dragonfly.NewParticipant(suite.Server, s.identity, &dragonfly.SetUpParams{
+		Suite:            req.Suite,
+		Pw:               string(cred.W),
+		SSID:             []byte(s.identity),
+		OpponentIdentity: req.Identity,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := s.startHandshake(participant, req.Identity, source(r), true)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+
+	// Create a response struct
+	res := dragonfly.HelloResponse{
+		Identity:  s.identity,
+		Suite:     string(participant.Suite.Name),
+		SessionID: id,
+		SSID:      []byte(s.identity),
 	}
 
 	// Encode the response into JSON and send it
@@ -78,6 +440,62 @@ func (s *Server) HandleHello(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// startHandshake turns away a blocked peer or source, then registers a new session with h in progress
+// and returns its ID. finishProves tells whether Finish tests the client's password, the attempt is
+// counted there. h is wiped when the handshake is refused or there is no room for it.
+func (s *Server) startHandshake(h spake2.Handshake, peer, source string, finishProves bool) (string, error) {
+	if err := s.limits.check(peer, source); err != nil {
+		h.Close()
+		return "", err
+	}
+
+	cs, err := s.sessions.start(peer)
+	if err != nil {
+		h.Close()
+		return "", err
+	}
+	defer s.sessions.release(cs)
+
+	cs.handshake = h
+	cs.source, cs.finishProves = source, finishProves
+	return cs.id, nil
+}
+
+// settle records how an attempt counted with limits.begin ended, err is what testing the client's
+// guess returned
+func (s *Server) settle(peer, source string, err error) {
+	record, outcome := s.limits.succeed, "login"
+	if err != nil {
+		record, outcome = s.limits.failed, "failed login"
+	}
+
+	if err := record(peer, source); err != nil {
+		s.log.Println("Recording the", outcome, "of", peer, "failed:", err)
+	}
+}
+
+// confirmationFailed answers a client whose confirmation didn't check out, with how long it has to
+// wait before its next attempt
+func (s *Server) confirmationFailed(w http.ResponseWriter, identity, source string, err error) {
+	if wait := s.limits.retryAfter(identity, source); wait > 0 {
+		setRetryAfter(w, wait)
+	}
+
+	http.Error(w, "while confirming the client: "+err.Error(), http.StatusBadRequest)
+}
+
+// sessionError answers a request whose session couldn't be found or started
+func sessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTooManySessions):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, errUnknownSession), errors.Is(err, errSessionExists), errors.Is(err, errTokenSpent):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		limitError(w, err)
+	}
+}
+
 // HandleClientPublicKey handles public key presented by client
 func (s *Server) HandleClientPublicKey(w http.ResponseWriter, r *http.Request) {
 
@@ -89,20 +507,39 @@ func (s *Server) HandleClientPublicKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Printf("Received a PA from %s: %s", s.spake.OpponentIdentity, req.PubliCKey.String())
+	if req.State != nil {
+		s.respondFromState(w, r, req)
+		return
+	}
 
-	point, err := s.spake.ComputepPoint()
+	cs, err := s.sessions.acquire(req.SessionID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		sessionError(w, err)
+		return
+	}
+	defer s.sessions.release(cs)
+
+	if cs.handshake == nil {
+		http.Error(w, "no handshake in progress", http.StatusBadRequest)
 		return
 	}
 
-	s.spake.ComputepGroupElement(req.PubliCKey)
-	s.spake.ComputeTranscript()
-	s.spake.DeriveKeys()
+	msg, err := cs.handshake.Start()
+	if err != nil {
+		s.sessions.remove(cs)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cs.confirmation, err = cs.handshake.Respond(req.Message)
+	if err != nil {
+		s.sessions.remove(cs)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Create a response struct
-	res := spake2.SPAKE2PublicKeyResponse{PublicKey: point}
+	res := spake2.SPAKE2PublicKeyResponse{Message: msg}
 
 	// Encode the response into JSON and send it
 	err = json.NewEncoder(w).Encode(res)
@@ -112,7 +549,7 @@ func (s *Server) HandleClientPublicKey(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// HandleClientPublicKey handles public key presented by client
+// HandleClientMAC handles the confirmation message presented by client
 func (s *Server) HandleClientMAC(w http.ResponseWriter, r *http.Request) {
 
 	// Decode the request body into the struct
@@ -123,16 +560,48 @@ func (s *Server) HandleClientMAC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Printf("Received a MAC from %s: %s", s.spake.OpponentIdentity, string(req.MACMessage[:]))
+	if req.State != nil {
+		s.finishFromState(w, r, req)
+		return
+	}
+
+	cs, err := s.sessions.acquire(req.SessionID)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	defer s.sessions.release(cs)
+
+	if cs.handshake == nil || cs.confirmation == nil {
+		http.Error(w, "no handshake in progress", http.StatusBadRequest)
+		return
+	}
+
+	// the MAC is where the client's guess is tested, a blocked client doesn't get to try it
+	if cs.finishProves {
+		if err := s.limits.begin(cs.peer, cs.source); err != nil {
+			s.sessions.remove(cs)
+			limitError(w, err)
+			return
+		}
+	}
 
-	confirm, err := s.spake.ConfirmMAC(req.MACMessage)
-	if err != nil || !confirm {
-		http.Error(w, "while confirming client MAC message", http.StatusBadRequest)
+	// a handshake gets one try, the secrets go either way and so does the session if it failed
+	cs.session, err = cs.handshake.Finish(req.Message)
+	if cs.finishProves {
+		s.settle(cs.peer, cs.source, err)
+		cs.confirmed = err == nil
+	}
+	if err != nil {
+		s.sessions.remove(cs)
+		s.confirmationFailed(w, cs.peer, cs.source, err)
 		return
 	}
+	cs.endHandshake()
 
 	// Create a response struct
-	res := spake2.SPAKE2MACResponse{MACMessage: s.spake.ProduceMacMessage()}
+	res := spake2.SPAKE2MACResponse{Message: cs.confirmation}
+	cs.confirmation = nil
 
 	// Encode the response into JSON and send it
 	err = json.NewEncoder(w).Encode(res)
@@ -142,15 +611,426 @@ func (s *Server) HandleClientMAC(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) addFeatures() {
-	http.HandleFunc("/hello", s.HandleHello)
-	http.HandleFunc("/clientPublicKey", s.HandleClientPublicKey)
-	http.HandleFunc("/clientMAC", s.HandleClientMAC)
+// respondFromState continues a plain SPAKE2 handshake from its state token: our share goes back
+// with a new token holding the derived keys, the one presented can't be used again
+func (s *Server) respondFromState(w http.ResponseWriter, r *http.Request, req spake2.SPAKE2PublickeyRequest) {
+	participant, err := s.stateTokens.Open(req.State, []byte(req.SessionID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer participant.Close()
+
+	cs, err := s.sessions.claim(req.SessionID, participant.OpponentIdentity, participant.State())
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	defer s.sessions.release(cs)
+
+	msg, err := participant.Start()
+	if err != nil {
+		s.sessions.remove(cs)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := participant.Respond(req.Message); err != nil {
+		s.sessions.remove(cs)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state, err := s.stateTokens.Seal(participant, []byte(req.SessionID))
+	if err != nil {
+		s.sessions.remove(cs)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cs.step = participant.State()
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(spake2.SPAKE2PublicKeyResponse{Message: msg, State: state})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// finishFromState checks the client's MAC against the keys in the state token and keeps the session
+// under the ID issued at the hello. The token is taken once, a handshake gets a single guess like the
+// others, and the MAC counts as an attempt because it is where the guess is tested.
+func (s *Server) finishFromState(w http.ResponseWriter, r *http.Request, req spake2.SPAKE2MACRequest) {
+	participant, err := s.stateTokens.Open(req.State, []byte(req.SessionID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer participant.Close()
+
+	cs, err := s.sessions.claim(req.SessionID, participant.OpponentIdentity, participant.State())
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	defer s.sessions.release(cs)
+
+	cs.source = source(r)
+	if err := s.limits.begin(cs.peer, cs.source); err != nil {
+		s.sessions.remove(cs)
+		limitError(w, err)
+		return
+	}
+
+	session, err := participant.Finish(req.Message)
+	s.settle(cs.peer, cs.source, err)
+	if err != nil {
+		s.sessions.remove(cs)
+		s.confirmationFailed(w, cs.peer, cs.source, err)
+		return
+	}
+	cs.session, cs.confirmed = session, true
+
+	confirmation, err := participant.ConfirmMessage()
+	if err != nil {
+		s.sessions.remove(cs)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(spake2.SPAKE2MACResponse{Message: confirmation})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleOPAQUERegistration evaluates the blinded password of a client registering with OPAQUE
+func (s *Server) HandleOPAQUERegistration(w http.ResponseWriter, r *http.Request) {
+	// Decode the request body into the struct
+	var req opaque.RegistrationRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.log.Println("Received an OPAQUE registration from:", req.Identity)
+
+	if err := s.checkOPAQUERegistration(req.Identity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	server, err := opaque.NewServer(s.identity, s.opaqueKeys, &opaque.SetUpParams{
+		Suite:            req.Suite,
+		Context:          []byte(opaqueContext),
+		OpponentIdentity: req.Identity,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg, err := server.RegistrationResponse(req.Message, req.Identity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Create a response struct
+	res := opaque.RegistrationResponse{
+		Identity: s.identity,
+		Suite:    string(server.Suite.Name),
+		Context:  []byte(opaqueContext),
+		Message:  msg,
+	}
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleOPAQUERecord stores the record uploaded at the end of an OPAQUE registration
+func (s *Server) HandleOPAQUERecord(w http.ResponseWriter, r *http.Request) {
+	// Decode the request body into the struct
+	var req opaque.RegistrationRecordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkOPAQUERegistration(req.Identity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record, err := opaque.DecodeRecord(suite.NewP256Suite(), req.Message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.envelopes.Put(req.Identity, record)
+
+	s.log.Println("Stored the OPAQUE record of:", req.Identity)
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(opaque.RegistrationRecordResponse{Identity: s.identity})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
-//TODO: after mac-ing, decrypt and enceypt evey message from and to client
+// checkOPAQUERegistration only lets known clients register, and only once: anyone could otherwise
+// replace a record, changing it needs a channel where the client is already authenticated
+func (s *Server) checkOPAQUERegistration(identity string) error {
+	cred, err := s.credentials.Lookup(identity)
+	if errors.Is(err, ErrUnknownIdentity) {
+		return fmt.Errorf("UnRecognized Client Identity")
+	}
+	if err != nil {
+		return err
+	}
+	cred.wipe()
+
+	if _, ok := s.envelopes.Get(identity); ok {
+		return fmt.Errorf("%s is already registered", identity)
+	}
 
-// getClienMapping returns clients mapped to their password
-func (s *Server) getClienMapping() (map[string]string, error) {
-	return clientPasswordMap, nil
+	return nil
 }
+
+// HandleOPAQUELogin answers KE1 with KE2
+func (s *Server) HandleOPAQUELogin(w http.ResponseWriter, r *http.Request) {
+	// Decode the request body into the struct
+	var req opaque.LoginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.log.Println("Received an OPAQUE login from:", req.Identity)
+
+	// an identity that never registered gets a fake record instead of an error, its login looks like
+	// any other until KE3 fails, so nobody can ask the server who has an account
+	record, ok := s.envelopes.Get(req.Identity)
+	if !ok {
+		record, err = s.opaqueKeys.FakeRecord(suite.NewP256Suite(), req.Identity)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := s.limits.check(req.Identity, source(r)); err != nil {
+		limitError(w, err)
+		return
+	}
+
+	login, err := opaque.NewServer(s.identity, s.opaqueKeys, &opaque.SetUpParams{
+		Suite:            req.Suite,
+		Context:          []byte(opaqueContext),
+		OpponentIdentity: req.Identity,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cs, err := s.sessions.start(req.Identity)
+	if err != nil {
+		login.Close()
+		sessionError(w, err)
+		return
+	}
+	defer s.sessions.release(cs)
+	cs.opaqueLogin = login
+	cs.source, cs.finishProves = source(r), true
+
+	msg, err := login.Respond(record, req.Identity, req.Message)
+	if err != nil {
+		s.sessions.remove(cs)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the client tests its guess on KE2 without us, so the attempt is counted right before KE2 goes out
+	// and only taken back once KE3 shows it was right. A login given up after KE2 slows the next ones
+	// down, only a KE3 that doesn't check out counts toward the lockout.
+	if err := s.limits.begin(req.Identity, source(r)); err != nil {
+		s.sessions.remove(cs)
+		limitError(w, err)
+		return
+	}
+
+	// Create a response struct
+	res := opaque.LoginResponse{Identity: s.identity, SessionID: cs.id, Message: msg}
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleOPAQUELoginFinish checks KE3 and keeps the session
+func (s *Server) HandleOPAQUELoginFinish(w http.ResponseWriter, r *http.Request) {
+	// Decode the request body into the struct
+	var req opaque.LoginFinishRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cs, err := s.sessions.acquire(req.SessionID)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	defer s.sessions.release(cs)
+
+	if cs.opaqueLogin == nil {
+		http.Error(w, "no OPAQUE login in progress", http.StatusBadRequest)
+		return
+	}
+
+	cs.session, err = cs.opaqueLogin.Finish(req.Message)
+	s.settle(cs.peer, cs.source, err)
+	if err != nil {
+		s.sessions.remove(cs)
+		s.confirmationFailed(w, cs.peer, cs.source, err)
+		return
+	}
+	cs.endHandshake()
+	cs.confirmed = true
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(opaque.LoginFinishResponse{Identity: s.identity})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleMessage opens a message sealed with the client's session and answers it
+func (s *Server) HandleMessage(w http.ResponseWriter, r *http.Request) {
+	// Decode the request body into the struct
+	var req spake2.SPAKE2MessageRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cs, err := s.sessions.acquire(req.SessionID)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	defer s.sessions.release(cs)
+
+	if cs.session == nil {
+		http.Error(w, "no session established", http.StatusBadRequest)
+		return
+	}
+
+	// without key confirmation, like J-PAKE, the first message is where the client's guess is tested
+	if !cs.confirmed {
+		s.confirmMessage(w, cs, req.Message)
+		return
+	}
+
+	plaintext, err := cs.session.Open(req.Message, []byte(cs.peer))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.reply(w, cs, plaintext)
+}
+
+// confirmMessage opens the first message of a session established without key confirmation as a
+// counted attempt, a session whose first message doesn't open is dropped
+func (s *Server) confirmMessage(w http.ResponseWriter, cs *clientSession, message []byte) {
+	if err := s.limits.begin(cs.peer, cs.source); err != nil {
+		s.sessions.remove(cs)
+		limitError(w, err)
+		return
+	}
+
+	plaintext, err := cs.session.Open(message, []byte(cs.peer))
+	s.settle(cs.peer, cs.source, err)
+	if err != nil {
+		s.sessions.remove(cs)
+		s.confirmationFailed(w, cs.peer, cs.source, err)
+		return
+	}
+	cs.confirmed = true
+
+	s.reply(w, cs, plaintext)
+}
+
+// reply answers a message the client sealed with its session
+func (s *Server) reply(w http.ResponseWriter, cs *clientSession, plaintext []byte) {
+	reply, err := cs.session.Seal([]byte("Hello "+cs.peer), []byte(cs.peer))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Encode the response into JSON and send it
+	err = json.NewEncoder(w).Encode(spake2.SPAKE2MessageResponse{Message: reply})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// addFeatures registers every endpoint under prefix on our own mux
+func (s *Server) addFeatures(prefix string) {
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc(prefix+"/hello", s.HandleHello)
+	s.mux.HandleFunc(prefix+"/spake2plus/hello", s.HandleSPAKE2PlusHello)
+	s.mux.HandleFunc(prefix+"/cpace/hello", s.HandleCPaceHello)
+	s.mux.HandleFunc(prefix+"/jpake/hello", s.HandleJPAKEHello)
+	s.mux.HandleFunc(prefix+"/dragonfly/hello", s.HandleDragonflyHello)
+	s.mux.HandleFunc(prefix+"/opaque/register", s.HandleOPAQUERegistration)
+	s.mux.HandleFunc(prefix+"/opaque/register/finish", s.HandleOPAQUERecord)
+	s.mux.HandleFunc(prefix+"/opaque/login", s.HandleOPAQUELogin)
+	s.mux.HandleFunc(prefix+"/opaque/login/finish", s.HandleOPAQUELoginFinish)
+	s.mux.HandleFunc(prefix+"/clientPublicKey", s.HandleClientPublicKey)
+	s.mux.HandleFunc(prefix+"/clientMAC", s.HandleClientMAC)
+	s.mux.HandleFunc(prefix+"/message", s.HandleMessage)
+}
+
+// lookup returns the credential of identity for the suite asked, or answers the request itself
+// when there is none
+func (s *Server) lookup(w http.ResponseWriter, identity string, suiteName suite.SuiteOptions) (*Credential, bool) {
+	cred, err := s.credentials.Lookup(identity)
+	if errors.Is(err, ErrUnknownIdentity) {
+		http.Error(w, "UnRecognized Client Identity", http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if cred.Suite != suiteName {
+		cred.wipe()
+		http.Error(w, fmt.Sprintf("the credential of %s is for suite %s", identity, cred.Suite), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return cred, true
+}
+
+var _ http.Handler = (*Server)(nil)
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"container/heap"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/opaque"
+)
+
+var (
+	// errUnknownSession is returned for a session ID we never issued, or one that expired or failed
+	errUnknownSession = errors.New("unknown or expired session")
+
+	// errTooManySessions is returned when the cap on concurrent sessions is reached
+	errTooManySessions = errors.New("too many sessions, try again later")
+
+	// errSessionExists is returned when a session is added under an ID already in use
+	errSessionExists = errors.New("session already exists")
+
+	// errTokenSpent is returned for a state token of a plain SPAKE2 handshake that was already used,
+	// or whose handshake finished or failed
+	errTokenSpent = errors.New("state token already used")
+)
+
+// clientSession is what the server keeps for one client between requests. Its mutex is held for the
+// whole of a request, so the requests of one client run one at a time while other clients go ahead.
+type clientSession struct {
+	mu           sync.Mutex
+	id           string
+	peer         string           // identity of the client
+	source       string           // address the handshake came from
+	finishProves bool             // Finish tests the client's password, otherwise its first message does
+	confirmed    bool             // the client showed it knows the password
+	carried      bool             // a plain SPAKE2 handshake, carried in state tokens instead of handshake
+	step         spake2.State     // while carried and half-open, the state of the only token still accepted
+	handshake    spake2.Handshake // handshake in progress, SPAKE2+, CPace, J-PAKE or Dragonfly
+	confirmation []byte           // our Respond message, held back until the client's is checked
+	opaqueLogin  *opaque.Server   // OPAQUE login in progress, it doesn't fit Handshake
+	session      *spake2.Session  // set once the handshake finished
+	closed       bool             // set once removed, a request that was waiting on mu finds out it's gone
+	expires      time.Time        // guarded by the manager's mutex, not mu
+	index        int              // position in the manager's expiry queue, guarded by its mutex
+}
+
+// endHandshake wipes the secrets of the handshake in progress once it finished or failed, the session stays
+func (cs *clientSession) endHandshake() {
+	if cs.handshake != nil {
+		cs.handshake.Close()
+		cs.handshake = nil
+	}
+	if cs.opaqueLogin != nil {
+		cs.opaqueLogin.Close()
+		cs.opaqueLogin = nil
+	}
+}
+
+// close wipes the handshake and the session, cs.mu must be held
+func (cs *clientSession) close() {
+	cs.endHandshake()
+	if cs.session != nil {
+		cs.session.Close()
+		cs.session = nil
+	}
+	cs.confirmation = nil
+	cs.closed = true
+}
+
+// sessionManager hands out session IDs and keeps the sessions they name. Half-open sessions expire
+// handshakeTTL after the last step, established ones once idle for idleTTL. The IDs of plain SPAKE2
+// handshakes stay spent once they are gone, until every state token issued under them expired.
+// Requests only take out what expired, found at the front of the queues, the rest isn't looked at.
+type sessionManager struct {
+	mu           sync.Mutex
+	sessions     map[string]*clientSession
+	queue        expiryQueue          // the sessions, soonest to expire first
+	spent        map[string]time.Time // when the tokens of a forgotten plain SPAKE2 handshake expire
+	spentQueue   []spentID            // the spent IDs in the order they expire
+	handshakeTTL time.Duration
+	idleTTL      time.Duration
+	max          int // cap on sessions, half-open or established
+}
+
+// spentID is a spent ID and when its tokens expire. All of them get the same lifetime, so IDs expire
+// in the order they were spent.
+type spentID struct {
+	id    string
+	until time.Time
+}
+
+func newSessionManager(handshakeTTL, idleTTL time.Duration, max int) *sessionManager {
+	return &sessionManager{
+		sessions:     make(map[string]*clientSession),
+		spent:        make(map[string]time.Time),
+		handshakeTTL: handshakeTTL,
+		idleTTL:      idleTTL,
+		max:          max,
+	}
+}
+
+// newSessionID returns 128 random bits, an ID can't be guessed to hijack someone else's session
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// start registers a half-open session for peer and returns it locked, release unlocks it
+func (m *sessionManager) start(peer string) (*clientSession, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &clientSession{id: id, peer: peer}
+	cs.mu.Lock()
+
+	if err := m.add(cs, m.handshakeTTL); err != nil {
+		cs.mu.Unlock()
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+// claim returns locked the plain SPAKE2 handshake named by id for a state token at step, so each
+// token is used once. A handshake this server doesn't know, because another server holding the same
+// state key or the one before a restart issued its hello, is registered like a new one.
+func (m *sessionManager) claim(id, peer string, step spake2.State) (*clientSession, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	expired := m.expiredLocked(now)
+
+	var err error
+	cs := m.sessions[id]
+	switch {
+	case cs != nil:
+	case !m.spent[id].IsZero():
+		err = errTokenSpent
+	case len(m.sessions) >= m.max:
+		err = errTooManySessions
+	default:
+		cs = &clientSession{id: id, peer: peer, carried: true, step: step, expires: now.Add(m.handshakeTTL)}
+		m.insertLocked(cs)
+	}
+	m.mu.Unlock()
+
+	closeAll(expired)
+	if err != nil {
+		return nil, err
+	}
+
+	cs.mu.Lock()
+	if cs.closed || !cs.carried || cs.session != nil || cs.step != step {
+		cs.mu.Unlock()
+		return nil, errTokenSpent
+	}
+
+	return cs, nil
+}
+
+// add inserts cs once expired sessions made room, if there is room
+func (m *sessionManager) add(cs *clientSession, ttl time.Duration) error {
+	m.mu.Lock()
+	expired := m.expiredLocked(time.Now())
+
+	var err error
+	switch {
+	case m.sessions[cs.id] != nil:
+		err = errSessionExists
+	case len(m.sessions) >= m.max:
+		err = errTooManySessions
+	default:
+		cs.expires = time.Now().Add(ttl)
+		m.insertLocked(cs)
+	}
+	m.mu.Unlock()
+
+	// wiped outside m.mu, a request may still hold one of them
+	closeAll(expired)
+
+	return err
+}
+
+// acquire returns the session named by id locked, release unlocks it
+func (m *sessionManager) acquire(id string) (*clientSession, error) {
+	m.mu.Lock()
+	cs := m.sessions[id]
+	if cs != nil && time.Now().After(cs.expires) {
+		cs = nil
+	}
+	m.mu.Unlock()
+
+	if cs == nil {
+		return nil, errUnknownSession
+	}
+
+	cs.mu.Lock()
+	if cs.closed {
+		cs.mu.Unlock()
+		return nil, errUnknownSession
+	}
+
+	return cs, nil
+}
+
+// release pushes the expiry of a locked session back and unlocks it
+func (m *sessionManager) release(cs *clientSession) {
+	if !cs.closed {
+		ttl := m.handshakeTTL
+		if cs.session != nil {
+			ttl = m.idleTTL
+		}
+
+		m.mu.Lock()
+		cs.expires = time.Now().Add(ttl)
+		if m.sessions[cs.id] == cs {
+			heap.Fix(&m.queue, cs.index)
+		}
+		m.mu.Unlock()
+	}
+
+	cs.mu.Unlock()
+}
+
+// remove forgets a locked session and wipes it, like a failed handshake
+func (m *sessionManager) remove(cs *clientSession) {
+	m.mu.Lock()
+	if m.sessions[cs.id] == cs {
+		m.forgetLocked(cs, time.Now())
+	}
+	m.mu.Unlock()
+
+	cs.close()
+}
+
+// insertLocked adds cs to the map and the expiry queue, m.mu must be held
+func (m *sessionManager) insertLocked(cs *clientSession) {
+	m.sessions[cs.id] = cs
+	heap.Push(&m.queue, cs)
+}
+
+// expiredLocked takes the expired sessions out of the map and drops the spent IDs whose tokens
+// expired, m.mu must be held
+func (m *sessionManager) expiredLocked(now time.Time) []*clientSession {
+	var expired []*clientSession
+	for len(m.queue) > 0 && now.After(m.queue[0].expires) {
+		cs := m.queue[0]
+		m.forgetLocked(cs, now)
+		expired = append(expired, cs)
+	}
+	for len(m.spentQueue) > 0 && now.After(m.spentQueue[0].until) {
+		if m.spent[m.spentQueue[0].id] == m.spentQueue[0].until {
+			delete(m.spent, m.spentQueue[0].id)
+		}
+		m.spentQueue = m.spentQueue[1:]
+	}
+
+	return expired
+}
+
+// forgetLocked takes cs out of the map, the ID of a plain SPAKE2 handshake stays spent until the
+// tokens issued under it expired. Tokens expire on whole seconds, hence the extra one.
+func (m *sessionManager) forgetLocked(cs *clientSession, now time.Time) {
+	delete(m.sessions, cs.id)
+	heap.Remove(&m.queue, cs.index)
+	if cs.carried {
+		until := now.Add(m.handshakeTTL + time.Second)
+		m.spent[cs.id] = until
+		m.spentQueue = append(m.spentQueue, spentID{cs.id, until})
+	}
+}
+
+// expiryQueue is a min-heap of sessions by expiry for container/heap, it keeps their index up to date
+type expiryQueue []*clientSession
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].expires.Before(q[j].expires) }
+
+func (q expiryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *expiryQueue) Push(x any) {
+	cs := x.(*clientSession)
+	cs.index = len(*q)
+	*q = append(*q, cs)
+}
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	cs := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+
+	return cs
+}
+
+func closeAll(sessions []*clientSession) {
+	for _, cs := range sessions {
+		cs.mu.Lock()
+		cs.close()
+		cs.mu.Unlock()
+	}
+}
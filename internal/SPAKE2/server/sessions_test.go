@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+)
+
+// TestClaimTakesTokensOnce walks a plain SPAKE2 handshake through its tokens and checks none of them
+// is taken twice, not even once the handshake is gone
+func TestClaimTakesTokensOnce(t *testing.T) {
+	m := newSessionManager(time.Minute, time.Minute, 10)
+
+	cs, err := m.start("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.carried, cs.step = true, spake2.StateSetUp
+	m.release(cs)
+
+	cs, err = m.claim(cs.id, "alice", spake2.StateSetUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.step = spake2.StateKeyDerived
+	m.release(cs)
+
+	if _, err := m.claim(cs.id, "alice", spake2.StateSetUp); !errors.Is(err, errTokenSpent) {
+		t.Fatalf("hello token taken twice: got %v, want %v", err, errTokenSpent)
+	}
+
+	cs, err = m.claim(cs.id, "alice", spake2.StateKeyDerived)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.remove(cs)
+
+	if _, err := m.claim(cs.id, "alice", spake2.StateKeyDerived); !errors.Is(err, errTokenSpent) {
+		t.Fatalf("token of a failed handshake taken again: got %v, want %v", err, errTokenSpent)
+	}
+}
+
+// TestClaimCountsAgainstCap checks handshakes started on another server take a place like any other
+func TestClaimCountsAgainstCap(t *testing.T) {
+	m := newSessionManager(time.Minute, time.Minute, 1)
+
+	cs, err := m.claim("issued elsewhere", "alice", spake2.StateSetUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.release(cs)
+
+	if _, err := m.start("bob"); !errors.Is(err, errTooManySessions) {
+		t.Fatalf("past the cap: got %v, want %v", err, errTooManySessions)
+	}
+	if _, err := m.claim("issued elsewhere too", "bob", spake2.StateSetUp); !errors.Is(err, errTooManySessions) {
+		t.Fatalf("past the cap: got %v, want %v", err, errTooManySessions)
+	}
+}
+
+// TestExpiry fills the table, keeps one session busy and checks the others expire to make room while
+// the busy one stays, and that the expiry queue and the spent IDs only hold what is still around
+func TestExpiry(t *testing.T) {
+	m := newSessionManager(100*time.Millisecond, time.Hour, 3)
+
+	ids := make([]string, 3)
+	for i := range ids {
+		cs, err := m.start("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs.carried = true
+		ids[i] = cs.id
+		m.release(cs)
+	}
+	if _, err := m.start("bob"); !errors.Is(err, errTooManySessions) {
+		t.Fatalf("past the cap: got %v, want %v", err, errTooManySessions)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	busy, err := m.acquire(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.release(busy)
+
+	time.Sleep(60 * time.Millisecond)
+	cs, err := m.start("bob")
+	if err != nil {
+		t.Fatalf("expired sessions didn't make room: %v", err)
+	}
+	m.release(cs)
+
+	if _, err := m.acquire(ids[0]); !errors.Is(err, errUnknownSession) {
+		t.Fatalf("expired session: got %v, want %v", err, errUnknownSession)
+	}
+	busy, err = m.acquire(ids[1])
+	if err != nil {
+		t.Fatalf("session in use expired: %v", err)
+	}
+	m.release(busy)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.queue) != len(m.sessions) || len(m.sessions) != 2 {
+		t.Fatalf("%d sessions and %d queued, want 2 of each", len(m.sessions), len(m.queue))
+	}
+	for i, cs := range m.queue {
+		if cs.index != i || m.sessions[cs.id] != cs {
+			t.Fatalf("queue entry %d out of place", i)
+		}
+	}
+	if len(m.spent) != 2 || len(m.spentQueue) != 2 {
+		t.Fatalf("%d spent IDs and %d queued, want the 2 expired handshakes", len(m.spent), len(m.spentQueue))
+	}
+}
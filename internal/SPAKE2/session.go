@@ -0,0 +1,262 @@
+package spake2
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrAuthenticationFailed is returned by Open when a message was tampered with or sealed under another key
+	ErrAuthenticationFailed = errors.New("spake2: message authentication failed")
+
+	// ErrSessionClosed is returned by every Session method once Close was called
+	ErrSessionClosed = errors.New("spake2: session closed")
+)
+
+// Session is what a finished handshake hands to the application, it only exposes message protection.
+// Each direction has its own key and IV, so a message can't be reflected back to its sender, and every
+// record carries a sequence number so it can't be replayed either.
+type Session struct {
+	Role         suite.Role // Client or Server, a symmetric peer gets the one TrafficRole picked
+	Identity     string
+	PeerIdentity string
+	suite        *suite.Suite
+	mu           sync.Mutex
+	send         direction
+	sendSeq      uint64 // sequence number of the next record we seal
+	receive      direction
+	window       replayWindow
+	exporter     []byte // exporter secret, see ExportKeyingMaterial
+	closed       bool
+}
+
+// direction is the traffic secret of one direction and the AEAD and IV derived from it
+type direction struct {
+	secret []byte
+	aead   cipher.AEAD
+	iv     []byte
+}
+
+// traffic labels, the client and server send under their own
+const (
+	clientLabel   = "client to server"
+	serverLabel   = "server to client"
+	updateLabel   = "traffic upd"
+	exporterLabel = "exporter master"
+)
+
+// NewSession derives the traffic keys of both directions from the key agreed by a PAKE handshake.
+// Symmetric peers pass the role TrafficRole picked for them.
+func NewSession(s *suite.Suite, role suite.Role, identity, peerIdentity string, key []byte) (*Session, error) {
+	sendLabel, receiveLabel, err := trafficLabels(role)
+	if err != nil {
+		return nil, err
+	}
+
+	// HKDF-Expand wants a pseudorandom key of the hash's size, Ke of SPAKE2 is only half of that.
+	// Extract it into one first, like TLS 1.3 extracts its secrets before expanding them.
+	master := hkdf.Extract(s.NewHash, key, nil)
+	defer suite.Wipe(master)
+
+	session := &Session{
+		Role:         role,
+		Identity:     identity,
+		PeerIdentity: peerIdentity,
+		suite:        s,
+		exporter:     expand(s, master, exporterLabel, s.NewHash().Size()),
+	}
+
+	if session.send, err = newDirection(s, expand(s, master, sendLabel, s.NewHash().Size())); err != nil {
+		return nil, err
+	}
+	if session.receive, err = newDirection(s, expand(s, master, receiveLabel, s.NewHash().Size())); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// trafficLabels returns the labels we send and receive under
+func trafficLabels(role suite.Role) (send, receive string, err error) {
+	switch role {
+	case suite.Client:
+		return clientLabel, serverLabel, nil
+	case suite.Server:
+		return serverLabel, clientLabel, nil
+	case suite.Symmetric:
+		return "", "", errors.New("spake2: symmetric sessions take their role from TrafficRole")
+	default:
+		return "", "", fmt.Errorf("spake2: unknown role %q", role)
+	}
+}
+
+// TrafficRole is the role a handshake participant passes to NewSession. Symmetric peers can't tell
+// client from server, the one whose encoded share sorts first sends under the server's label, the way
+// it takes A's place in a symmetric SPAKE2 transcript. Identities play no part, they may be equal or empty.
+func TrafficRole(role suite.Role, ownShare, peerShare []byte) (suite.Role, error) {
+	if role != suite.Symmetric {
+		return role, nil
+	}
+
+	switch bytes.Compare(ownShare, peerShare) {
+	case -1:
+		return suite.Server, nil
+	case 1:
+		return suite.Client, nil
+	default:
+		return "", ErrInvalidPoint
+	}
+}
+
+// newDirection expands a traffic secret into the AEAD and IV of its direction
+func newDirection(s *suite.Suite, secret []byte) (direction, error) {
+	aeadKey := expand(s, secret, "key", s.AEADKeySize)
+	defer suite.Wipe(aeadKey)
+
+	aead, err := s.NewAEAD(aeadKey)
+	if err != nil {
+		return direction{}, err
+	}
+
+	return direction{secret: secret, aead: aead, iv: expand(s, secret, "iv", aead.NonceSize())}, nil
+}
+
+// next ratchets the secret like a TLS 1.3 key update and wipes the old secret and IV.
+// The AEAD keeps its own copy of the key, dropping it is all we can do for that one.
+func (d *direction) next(s *suite.Suite) error {
+	next, err := newDirection(s, expand(s, d.secret, updateLabel, len(d.secret)))
+	if err != nil {
+		return err
+	}
+
+	d.wipe()
+	*d = next
+
+	return nil
+}
+
+// wipe overwrites the secret and IV and drops the AEAD
+func (d *direction) wipe() {
+	suite.Wipe(d.secret)
+	suite.Wipe(d.iv)
+	*d = direction{}
+}
+
+// expand is HKDF-Expand of length bytes, the lengths asked here can't exceed what HKDF allows
+func expand(s *suite.Suite, secret []byte, label string, length int) []byte {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(s.NewHash, secret, []byte(label)), out); err != nil {
+		panic(err)
+	}
+
+	return out
+}
+
+// UpdateSendKey ratchets our send key, the old one is wiped and sequence numbers start over.
+// The peer has to call UpdateReceiveKey right after opening the last record sealed under the old key,
+// Conn takes care of that with a KeyUpdate record.
+func (s *Session) UpdateSendKey() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	if err := s.send.next(s.suite); err != nil {
+		return err
+	}
+	s.sendSeq = 0
+
+	return nil
+}
+
+// UpdateReceiveKey follows the peer's UpdateSendKey, records sealed under the old key no longer open
+func (s *Session) UpdateReceiveKey() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	if err := s.receive.next(s.suite); err != nil {
+		return err
+	}
+	s.window = replayWindow{}
+
+	return nil
+}
+
+// Seal encrypts and authenticates plaintext with our send key, and authenticates aad which isn't sent.
+// The record is the 8 byte sequence number followed by the AEAD output, the sequence number is bound
+// into the nonce.
+func (s *Session) Seal(plaintext, aad []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+
+	seq := s.sendSeq
+	if seq == math.MaxUint64 {
+		return nil, ErrSequenceExhausted
+	}
+	s.sendSeq++
+
+	record := make([]byte, seqLen, seqLen+len(plaintext)+s.send.aead.Overhead())
+	binary.BigEndian.PutUint64(record, seq)
+
+	return s.send.aead.Seal(record, recordNonce(s.send.iv, seq), plaintext, aad), nil
+}
+
+// Open checks and decrypts a record made by the peer's Seal with the same aad, using our receive key.
+// Records may arrive out of order within ReplayWindow, each is only accepted once.
+func (s *Session) Open(record, aad []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+	if len(record) < seqLen+s.receive.aead.Overhead() {
+		return nil, ErrAuthenticationFailed
+	}
+
+	seq := binary.BigEndian.Uint64(record[:seqLen])
+	if err := s.window.check(seq); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := s.receive.aead.Open(nil, recordNonce(s.receive.iv, seq), record[seqLen:], aad)
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+
+	// only authenticated records move the window, a forged sequence number can't block real ones
+	s.window.mark(seq)
+
+	return plaintext, nil
+}
+
+// Close overwrites the traffic and exporter secrets, the session can't seal or open anything afterwards
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.send.wipe()
+	s.receive.wipe()
+	suite.Wipe(s.exporter)
+	s.exporter = nil
+	s.closed = true
+}
@@ -0,0 +1,140 @@
+package spake2_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// newSessionPair returns both ends of a session keyed with the same key
+func newSessionPair(t *testing.T, name suite.SuiteOptions) (client, server *spake2.Session) {
+	t.Helper()
+
+	s := suite.SelectECCSuite(name)
+	key := []byte("test session key")
+
+	client, err := spake2.NewSession(s, suite.Client, "client", "server", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = spake2.NewSession(s, suite.Server, "server", "client", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, server
+}
+
+// TestSession seals records on one side and opens them on the other. A reflected record, a flipped bit
+// or different aad must fail authentication, a replayed or too old record must be refused, and so must
+// a record sealed before a key update.
+func TestSession(t *testing.T) {
+	// the AEADs come from the standard library and x/crypto, check that sessions wire them up right
+	for _, name := range []suite.SuiteOptions{suite.P256, suite.P256ChaCha} {
+		t.Run(string(name), func(t *testing.T) {
+			sender, receiver := newSessionPair(t, name)
+
+			records := make([][]byte, spake2.ReplayWindow+3)
+			for i := range records {
+				var err error
+				if records[i], err = sender.Seal([]byte(fmt.Sprint("record ", i)), []byte("aad")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expectErr := func(what string, record, aad []byte, want error) {
+				t.Helper()
+				if _, err := receiver.Open(record, aad); !errors.Is(err, want) {
+					t.Fatalf("%s: got %v, want %v", what, err, want)
+				}
+			}
+
+			// a record reflected back to its sender must not open
+			if _, err := sender.Open(records[0], []byte("aad")); !errors.Is(err, spake2.ErrAuthenticationFailed) {
+				t.Fatalf("reflected record: got %v, want %v", err, spake2.ErrAuthenticationFailed)
+			}
+			expectErr("different aad", records[0], []byte("other aad"), spake2.ErrAuthenticationFailed)
+
+			tampered := append([]byte{}, records[0]...)
+			tampered[len(tampered)-1] ^= 1
+			expectErr("tampered record", tampered, []byte("aad"), spake2.ErrAuthenticationFailed)
+
+			// out of order within the window is fine, but only once
+			for _, i := range []int{1, 0} {
+				plain, err := receiver.Open(records[i], []byte("aad"))
+				if err != nil || string(plain) != fmt.Sprint("record ", i) {
+					t.Fatalf("record %d: got %q, %v", i, plain, err)
+				}
+			}
+			expectErr("replayed record", records[1], []byte("aad"), spake2.ErrReplayedRecord)
+
+			// once the newest record is ReplayWindow ahead, record 2 can't be checked anymore
+			if _, err := receiver.Open(records[len(records)-1], []byte("aad")); err != nil {
+				t.Fatal("newest record: ", err)
+			}
+			expectErr("stale record", records[2], []byte("aad"), spake2.ErrStaleRecord)
+
+			// after a key update only records sealed under the new key open, starting over at sequence number 0
+			if err := sender.UpdateSendKey(); err != nil {
+				t.Fatal(err)
+			}
+			if err := receiver.UpdateReceiveKey(); err != nil {
+				t.Fatal(err)
+			}
+			updated, err := sender.Seal([]byte("updated"), []byte("aad"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain, err := receiver.Open(updated, []byte("aad")); err != nil || string(plain) != "updated" {
+				t.Fatalf("record after key update: got %q, %v", plain, err)
+			}
+			expectErr("record under the old key", records[3], []byte("aad"), spake2.ErrAuthenticationFailed)
+
+			receiver.Close()
+			expectErr("closed session", updated, []byte("aad"), spake2.ErrSessionClosed)
+		})
+	}
+}
+
+// TestExportKeyingMaterial pins an export, checks both ends of a session export the same keying
+// material, and that the label and the context each separate the exports
+func TestExportKeyingMaterial(t *testing.T) {
+	a, b := newSessionPair(t, suite.P256)
+
+	exports := make([][]byte, 4)
+	for i, input := range []struct {
+		session *spake2.Session
+		label   string
+		context []byte
+	}{
+		{a, "database key", []byte("users")},
+		{b, "database key", []byte("users")},
+		{a, "signing key", []byte("users")},
+		{a, "database key", []byte("orders")},
+	} {
+		export, err := input.session.ExportKeyingMaterial(input.label, input.context, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exports[i] = export
+	}
+
+	// HKDF-Extract of the session key, then Derive-Secret and HKDF-Expand-Label as RFC 8446 does
+	want := "c6b42bdf3d6ac28b786983641b7fb5309cda6d8e328677cb026c3b5f4767cd18"
+
+	switch {
+	case hex.EncodeToString(exports[0]) != want:
+		t.Fatalf("export: got %x, want %s", exports[0], want)
+	case !bytes.Equal(exports[0], exports[1]):
+		t.Fatal("peers exported different keying material")
+	case bytes.Equal(exports[0], exports[2]):
+		t.Fatal("exports with different labels are equal")
+	case bytes.Equal(exports[0], exports[3]):
+		t.Fatal("exports with different contexts are equal")
+	}
+}
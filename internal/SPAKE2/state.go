@@ -0,0 +1,75 @@
+package spake2
+
+import (
+	"fmt"
+	"strings"
+)
+
+// State is the position of a Participant in the SPAKE2 handshake
+type State int
+
+const (
+	StateInit       State = iota // created, nothing set up yet
+	StateSetUp                   // shared parameters and w are known
+	StateSent                    // our share has been computed and can be sent
+	StateKeyDerived              // transcript and keys derived, waiting for key confirmation
+	StateConfirmed               // peer's MAC verified, session keys may be used
+	StateClosed                  // handshake aborted or session ended
+)
+
+func (s State) String() string {
+	switch s {
+	case StateInit:
+		return "Init"
+	case StateSetUp:
+		return "SetUp"
+	case StateSent:
+		return "Sent"
+	case StateKeyDerived:
+		return "KeyDerived"
+	case StateConfirmed:
+		return "Confirmed"
+	case StateClosed:
+		return "Closed"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// StateError is returned when a Participant method is called out of order
+type StateError struct {
+	Op       string  // method that was called
+	State    State   // state the participant was in
+	Expected []State // states in which Op is allowed
+	Reason   string  // extra detail when the state alone doesn't explain it
+}
+
+func (e *StateError) Error() string {
+	expected := make([]string, len(e.Expected))
+	for i, s := range e.Expected {
+		expected[i] = s.String()
+	}
+
+	msg := fmt.Sprintf("spake2: %s called in state %s, expected %s", e.Op, e.State, strings.Join(expected, " or "))
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+
+	return msg
+}
+
+// State returns the current handshake state of the participant
+func (user *Participant) State() State {
+	return user.state
+}
+
+// expectState returns a StateError unless the participant is in one of the given states
+func (user *Participant) expectState(op string, states ...State) error {
+	for _, s := range states {
+		if user.state == s {
+			return nil
+		}
+	}
+
+	return &StateError{Op: op, State: user.state, Expected: states}
+}
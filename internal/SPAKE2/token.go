@@ -0,0 +1,232 @@
+package spake2
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrInvalidToken is returned for a state token that was tampered with, sealed under another key or expired
+var ErrInvalidToken = errors.New("spake2: invalid or expired state token")
+
+// StateTokens seals participants into tokens the client carries from one handshake message to the next,
+// so a server keeps nothing in memory between them and any server holding the same key can continue.
+// A token itself can be presented again until it expires, a server that wants each one used once has to
+// remember which it took, as server.Server does.
+type StateTokens struct {
+	aead cipher.AEAD
+	ttl  time.Duration
+}
+
+const (
+	// StateKeySize is the size of the key tokens are sealed with
+	StateKeySize = chacha20poly1305.KeySize
+
+	// tokenAAD binds tokens to their purpose, on top of the expiry
+	tokenAAD = "SPAKE2-playground handshake state"
+
+	// stateVersion is the first byte of a marshalled participant
+	stateVersion byte = 1
+
+	// stateFields is how many fields a marshalled participant has
+	stateFields = 15
+)
+
+// NewStateTokens returns tokens sealed with XChaCha20-Poly1305, whose random nonces are long enough
+// to never collide however many tokens a key seals. Tokens are refused ttl after they were issued.
+func NewStateTokens(key []byte, ttl time.Duration) (*StateTokens, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("spake2: state token key: %w", err)
+	}
+
+	return &StateTokens{aead: aead, ttl: ttl}, nil
+}
+
+// Seal encodes the participant's handshake state into a token: expiry || nonce || AEAD(state).
+// The token only opens along with the same id, which is authenticated but not part of it.
+// Only a participant between SetUp and key confirmation can be sealed.
+func (t *StateTokens) Seal(user *Participant, id []byte) ([]byte, error) {
+	state, err := user.marshalState()
+	if err != nil {
+		return nil, err
+	}
+	defer suite.Wipe(state)
+
+	token := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(t.ttl).Unix()))
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	token = append(token, nonce...)
+
+	return t.aead.Seal(token, nonce, state, t.aad(token[:8], id)), nil
+}
+
+// Open checks a token sealed with id and restores the participant sealed in it, the participant uses crypto/rand
+func (t *StateTokens) Open(token, id []byte) (*Participant, error) {
+	if len(token) < 8+t.aead.NonceSize()+t.aead.Overhead() {
+		return nil, ErrInvalidToken
+	}
+
+	expiry := int64(binary.BigEndian.Uint64(token[:8]))
+	if time.Now().Unix() > expiry {
+		return nil, ErrInvalidToken
+	}
+
+	nonce := token[8 : 8+t.aead.NonceSize()]
+	state, err := t.aead.Open(nil, nonce, token[8+len(nonce):], t.aad(token[:8], id))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	defer suite.Wipe(state)
+
+	return unmarshalState(state)
+}
+
+// aad authenticates the expiry and id along with the purpose of the token, the id goes last
+// so it needs no length
+func (t *StateTokens) aad(expiry, id []byte) []byte {
+	return append(append([]byte(tokenAAD), expiry...), id...)
+}
+
+// marshalState encodes every field needed to continue the handshake, each with a 2 byte length.
+// M, N, wM and wN are recomputed from the suite and w on the way back.
+func (user *Participant) marshalState() ([]byte, error) {
+	if err := user.expectState("marshalState", StateSetUp, StateSent, StateKeyDerived); err != nil {
+		return nil, err
+	}
+
+	fields := [][]byte{
+		{stateVersion, byte(user.state)},
+		[]byte(user.Suite.Name),
+		[]byte(user.Role),
+		[]byte(user.Identity),
+		[]byte(user.OpponentIdentity),
+		user.Suite.EncodeScalar(user.w),
+		user.encodeOptionalScalar(user.x),
+		user.encodeOptionalPoint(user.Pa),
+		user.encodeOptionalPoint(user.Pb),
+		user.encodeOptionalPoint(user.k),
+		user.tt,
+		user.hashedTT,
+		user.sessionConfirmationKey,
+		user.expectedConfirmationKey,
+		user.sessionPrivateKey,
+	}
+
+	var out []byte
+	for _, f := range fields {
+		out = binary.BigEndian.AppendUint16(out, uint16(len(f)))
+		out = append(out, f...)
+	}
+
+	// the encoded scalars are copies only made for this
+	suite.Wipe(fields[5])
+	suite.Wipe(fields[6])
+
+	return out, nil
+}
+
+// unmarshalState restores a participant encoded by marshalState
+func unmarshalState(data []byte) (*Participant, error) {
+	fields := make([][]byte, 0, stateFields)
+	for len(data) > 0 {
+		if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+			return nil, ErrInvalidToken
+		}
+
+		n := 2 + int(binary.BigEndian.Uint16(data))
+		fields = append(fields, data[2:n])
+		data = data[n:]
+	}
+	if len(fields) != stateFields || len(fields[0]) != 2 || fields[0][0] != stateVersion {
+		return nil, ErrInvalidToken
+	}
+
+	state := State(fields[0][1])
+	if state != StateSetUp && state != StateSent && state != StateKeyDerived {
+		return nil, ErrInvalidToken
+	}
+
+	w := new(big.Int).SetBytes(fields[5])
+	defer suite.WipeInt(w)
+
+	user := &Participant{Role: suite.Role(fields[2]), Identity: string(fields[3])}
+	err := user.SetUp(&SetUpParams{
+		OpponentIdentity: string(fields[4]),
+		Suite:            suite.SuiteOptions(fields[1]),
+		W:                w,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields[6]) > 0 {
+		user.x = new(big.Int).SetBytes(fields[6])
+		user.wm = user.Suite.Multiply(user.M, user.w).Clone()
+		user.wn = user.Suite.Multiply(user.N, user.w).Clone()
+	}
+	if user.Pa, err = user.decodeOptionalPoint(fields[7]); err != nil {
+		return nil, err
+	}
+	if user.Pb, err = user.decodeOptionalPoint(fields[8]); err != nil {
+		return nil, err
+	}
+	if user.k, err = user.decodeOptionalPoint(fields[9]); err != nil {
+		return nil, err
+	}
+
+	// copies, the decrypted state is wiped once the participant is restored
+	user.tt, user.hashedTT = nonEmpty(fields[10]), nonEmpty(fields[11])
+	user.sessionConfirmationKey, user.expectedConfirmationKey = nonEmpty(fields[12]), nonEmpty(fields[13])
+	user.sessionPrivateKey = nonEmpty(fields[14])
+
+	user.state = state
+	return user, nil
+}
+
+func (user *Participant) encodeOptionalScalar(n *big.Int) []byte {
+	if n == nil {
+		return nil
+	}
+
+	return user.Suite.EncodeScalar(n)
+}
+
+func (user *Participant) encodeOptionalPoint(p *suite.Point) []byte {
+	if p == nil {
+		return nil
+	}
+
+	return user.Suite.EncodePoint(p)
+}
+
+func (user *Participant) decodeOptionalPoint(b []byte) (*suite.Point, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	p, err := user.Suite.DecodePoint(b)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return p, nil
+}
+
+// nonEmpty keeps missing fields nil, like they are on a participant that didn't get that far
+func nonEmpty(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,103 @@
+package spake2_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// TestStateTokens runs the server side of a handshake through a state token at every step, as two
+// replicas sharing a key would, and checks a token is refused under another key, for another session
+// or tampered with
+func TestStateTokens(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, spake2.StateKeySize)
+	id := []byte("session")
+	replicas := make([]*spake2.StateTokens, 2)
+	for i := range replicas {
+		tokens, err := spake2.NewStateTokens(key, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas[i] = tokens
+	}
+
+	client, err := spake2.NewParticipant(suite.Client, "client", &spake2.SetUpParams{Pw: "password", OpponentIdentity: "server", Suite: suite.P256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := spake2.NewParticipant(suite.Server, "server", &spake2.SetUpParams{Pw: "password", OpponentIdentity: "client", Suite: suite.P256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := replicas[0].Seal(server, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	clientShare, err := client.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server, err = replicas[1].Open(token, id); err != nil {
+		t.Fatal(err)
+	}
+	serverShare, err := server.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Respond(clientShare); err != nil {
+		t.Fatal(err)
+	}
+	if token, err = replicas[1].Seal(server, id); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	clientConfirm, err := client.Respond(serverShare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server, err = replicas[0].Open(token, id); err != nil {
+		t.Fatal(err)
+	}
+	serverSession, err := server.Finish(clientConfirm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfirm, err := server.ConfirmMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSession, err := client.Finish(serverConfirm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !paketest.SessionsAgree(clientSession, serverSession) {
+		t.Fatal("restored server and client derived different keys")
+	}
+
+	other, err := spake2.NewStateTokens(bytes.Repeat([]byte{2}, spake2.StateKeySize), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(token, id); !errors.Is(err, spake2.ErrInvalidToken) {
+		t.Fatalf("token of another key: got %v, want %v", err, spake2.ErrInvalidToken)
+	}
+
+	if _, err := replicas[0].Open(token, []byte("another session")); !errors.Is(err, spake2.ErrInvalidToken) {
+		t.Fatalf("token of another session: got %v, want %v", err, spake2.ErrInvalidToken)
+	}
+
+	tampered := append([]byte{}, token...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := replicas[0].Open(tampered, id); !errors.Is(err, spake2.ErrInvalidToken) {
+		t.Fatalf("tampered token: got %v, want %v", err, spake2.ErrInvalidToken)
+	}
+}
@@ -0,0 +1,44 @@
+package spake2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"io"
+)
+
+// Transcript accumulates an RFC 9382 style TT while streaming it into the suite's hash
+type Transcript struct {
+	h   hash.Hash
+	buf bytes.Buffer
+	w   io.Writer
+}
+
+// NewTranscript starts an empty transcript hashed with h
+func NewTranscript(h hash.Hash) *Transcript {
+	t := &Transcript{h: h}
+	t.w = io.MultiWriter(t.h, &t.buf)
+	return t
+}
+
+// Append writes len(b) as an 8 byte little endian integer followed by b for every field
+func (t *Transcript) Append(fields ...[]byte) {
+	var length [8]byte
+	for _, b := range fields {
+		binary.LittleEndian.PutUint64(length[:], uint64(len(b)))
+
+		// writes to a hash or bytes.Buffer never fail
+		t.w.Write(length[:])
+		t.w.Write(b)
+	}
+}
+
+// Bytes returns the transcript written so far
+func (t *Transcript) Bytes() []byte {
+	return t.buf.Bytes()
+}
+
+// Sum returns Hash(TT)
+func (t *Transcript) Sum() []byte {
+	return t.h.Sum(nil)
+}
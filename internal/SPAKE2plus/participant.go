@@ -0,0 +1,389 @@
+// Package spake2plus implements the SPAKE2+ augmented PAKE of RFC 9383.
+// The client is the prover and knows the password, the server is the verifier and only keeps the
+// registration record (w0, L), so a stolen server database can't be used to impersonate clients directly.
+package spake2plus
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+	"golang.org/x/crypto/hkdf"
+)
+
+// message types, the first byte of every handshake message
+const (
+	msgShare   byte = 1 // shareP or shareV
+	msgConfirm byte = 2 // confirmP or confirmV
+)
+
+var (
+	// ErrInvalidShare is returned when the peer's share is not a valid point or leads to the identity
+	ErrInvalidShare = errors.New("spake2plus: invalid peer share")
+
+	// ErrConfirmationFailed is returned when the peer's confirmation doesn't match
+	ErrConfirmationFailed = errors.New("spake2plus: key confirmation failed")
+
+	// ErrUnexpectedMessage is returned when a handshake message is malformed or of the wrong type
+	ErrUnexpectedMessage = errors.New("spake2plus: unexpected handshake message")
+)
+
+// Participant runs one side of SPAKE2+, suite.Client is the prover and suite.Server the verifier.
+// The secrets are unexported and overwritten by Close.
+type Participant struct {
+	Suite            *suite.Suite
+	Role             suite.Role
+	Context          string // application context bound into the transcript
+	Identity         string
+	OpponentIdentity string
+	ShareP           *suite.Point
+	ShareV           *suite.Point
+	Rand             io.Reader // source of randomness, crypto/rand when nil
+	w0               *big.Int
+	w1               *big.Int     // prover only
+	l                *suite.Point // verifier only
+	x                *big.Int     // x for the prover, y for the verifier
+	z                *suite.Point
+	v                *suite.Point
+	tt               []byte
+	kMain            []byte
+	kConfirmP        []byte
+	kConfirmV        []byte
+	kShared          []byte
+	state            spake2.State
+}
+
+type SetUpParams struct {
+	Suite            suite.SuiteOptions
+	Context          string
+	OpponentIdentity string
+	Rand             io.Reader
+}
+
+// NewProver creates the client side from the secrets derived from its password
+func NewProver(identity string, secrets *Secrets, param *SetUpParams) (*Participant, error) {
+	user, err := newParticipant(suite.Client, identity, param)
+	if err != nil {
+		return nil, err
+	}
+
+	// our own copies, Close wipes them and the caller may log in again with the same secrets
+	user.w0 = new(big.Int).Set(secrets.W0)
+	user.w1 = new(big.Int).Set(secrets.W1)
+
+	return user, nil
+}
+
+// NewVerifier creates the server side from the client's registration record
+func NewVerifier(identity string, record *Record, param *SetUpParams) (*Participant, error) {
+	user, err := newParticipant(suite.Server, identity, param)
+	if err != nil {
+		return nil, err
+	}
+
+	user.w0, user.l, err = record.decode(user.Suite)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func newParticipant(role suite.Role, identity string, param *SetUpParams) (*Participant, error) {
+	s := suite.SelectECCSuite(param.Suite)
+	if s == nil {
+		return nil, fmt.Errorf("spake2plus: unknown suite %q", param.Suite)
+	}
+
+	return &Participant{
+		Suite:            s,
+		Role:             role,
+		Context:          param.Context,
+		Identity:         identity,
+		OpponentIdentity: param.OpponentIdentity,
+		Rand:             param.Rand,
+		state:            spake2.StateSetUp,
+	}, nil
+}
+
+// State returns the current handshake state of the participant
+func (user *Participant) State() spake2.State {
+	return user.state
+}
+
+// Start computes our share, shareP = x*G + w0*M for the prover and shareV = y*G + w0*N for the verifier
+func (user *Participant) Start() ([]byte, error) {
+	if err := user.expectState("Start", spake2.StateSetUp); err != nil {
+		return nil, err
+	}
+
+	random := user.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	x, err := rand.Int(random, user.Suite.Curve.Params().N)
+	if err != nil {
+		return nil, err
+	}
+	user.x = x
+
+	share := user.Suite.Add(user.Suite.BaseMultiply(x), user.Suite.Multiply(user.ownPoint(), user.w0))
+	if user.Role == suite.Client {
+		user.ShareP = share
+	} else {
+		user.ShareV = share
+	}
+
+	user.state = spake2.StateSent
+	return append([]byte{msgShare}, user.Suite.EncodePoint(share)...), nil
+}
+
+// Respond takes the peer's share, runs the key schedule and returns our confirmation message
+func (user *Participant) Respond(peerMsg []byte) ([]byte, error) {
+	if err := user.expectState("Respond", spake2.StateSent); err != nil {
+		return nil, err
+	}
+
+	payload, err := parseMessage(msgShare, peerMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := user.Suite.DecodePoint(payload)
+	if err != nil || peer.IsIdentity() {
+		return nil, ErrInvalidShare
+	}
+
+	// unblinded = h * (peer share - w0 * peer's point)
+	unblinded := user.Suite.Multiply(user.Suite.Subtract(peer, user.Suite.Multiply(user.peerPoint(), user.w0)), user.Suite.H)
+
+	if user.Role == suite.Client {
+		// Z = h*x*(shareV - w0*N), V = h*w1*(shareV - w0*N)
+		user.ShareV = peer
+		user.z = user.Suite.Multiply(unblinded, user.x).Clone()
+		user.v = user.Suite.Multiply(unblinded, user.w1).Clone()
+	} else {
+		// Z = h*y*(shareP - w0*M), V = h*y*L
+		user.ShareP = peer
+		user.z = user.Suite.Multiply(unblinded, user.x).Clone()
+		user.v = user.Suite.Multiply(user.Suite.Multiply(user.l, user.Suite.H), user.x).Clone()
+	}
+
+	if user.z.IsIdentity() || user.v.IsIdentity() {
+		return nil, ErrInvalidShare
+	}
+
+	user.computeTranscript()
+	user.deriveKeys()
+	user.state = spake2.StateKeyDerived
+
+	confirmation, err := user.confirmation(user.ownConfirmKey(), user.peerShare())
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{msgConfirm}, confirmation...), nil
+}
+
+// Finish verifies the peer's confirmation and returns the session keyed with K_shared
+func (user *Participant) Finish(peerMsg []byte) (*spake2.Session, error) {
+	if err := user.expectState("Finish", spake2.StateKeyDerived); err != nil {
+		return nil, err
+	}
+
+	payload, err := parseMessage(msgConfirm, peerMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the peer MACs our own share with its confirmation key
+	if !user.Suite.VerifyTag(user.peerConfirmKey(), user.ownShare(), payload) {
+		user.Close()
+		return nil, ErrConfirmationFailed
+	}
+
+	user.state = spake2.StateConfirmed
+	return spake2.NewSession(user.Suite, user.Role, user.Identity, user.OpponentIdentity, user.kShared)
+}
+
+// KeySchedule is a copy of the values a handshake derived, RFC 9383 publishes them in its test vectors.
+// It holds secrets, keep it out of logs.
+type KeySchedule struct {
+	Z         []byte // encoded points
+	V         []byte
+	TT        []byte
+	KMain     []byte
+	KConfirmP []byte
+	KConfirmV []byte
+	KShared   []byte
+}
+
+// KeySchedule returns a copy of the derived values. It is only available once Finish confirmed the
+// peer, and until Close.
+func (user *Participant) KeySchedule() (*KeySchedule, error) {
+	if user.state != spake2.StateConfirmed {
+		return nil, &spake2.StateError{Op: "KeySchedule", State: user.state, Expected: []spake2.State{spake2.StateConfirmed}}
+	}
+
+	return &KeySchedule{
+		Z:         user.Suite.EncodePoint(user.z),
+		V:         user.Suite.EncodePoint(user.v),
+		TT:        bytes.Clone(user.tt),
+		KMain:     bytes.Clone(user.kMain),
+		KConfirmP: bytes.Clone(user.kConfirmP),
+		KConfirmV: bytes.Clone(user.kConfirmV),
+		KShared:   bytes.Clone(user.kShared),
+	}, nil
+}
+
+// Close overwrites every secret of the handshake, the Session handed out by Finish isn't affected
+func (user *Participant) Close() {
+	suite.WipeInt(user.w0)
+	suite.WipeInt(user.w1)
+	user.l.Wipe()
+	suite.WipeInt(user.x)
+	user.z.Wipe()
+	user.v.Wipe()
+	for _, b := range [][]byte{user.tt, user.kMain, user.kConfirmP, user.kConfirmV, user.kShared} {
+		suite.Wipe(b)
+	}
+
+	user.w0, user.w1, user.l, user.x, user.z, user.v = nil, nil, nil, nil, nil, nil
+	user.tt, user.kMain, user.kConfirmP, user.kConfirmV, user.kShared = nil, nil, nil, nil, nil
+	user.state = spake2.StateClosed
+}
+
+// computeTranscript builds the RFC 9383 TT
+func (user *Participant) computeTranscript() {
+	// TT = len(Context) || Context
+	// || len(idProver) || idProver
+	// || len(idVerifier) || idVerifier
+	// || len(M) || M
+	// || len(N) || N
+	// || len(shareP) || shareP
+	// || len(shareV) || shareV
+	// || len(Z) || Z
+	// || len(V) || V
+	// || len(w0) || w0
+	idProver, idVerifier := user.Identity, user.OpponentIdentity
+	if user.Role == suite.Server {
+		idProver, idVerifier = idVerifier, idProver
+	}
+
+	s := user.Suite
+	t := spake2.NewTranscript(s.NewHash())
+	t.Append(
+		[]byte(user.Context),
+		[]byte(idProver),
+		[]byte(idVerifier),
+		s.EncodePoint(s.M),
+		s.EncodePoint(s.N),
+		s.EncodePoint(user.ShareP),
+		s.EncodePoint(user.ShareV),
+		s.EncodePoint(user.z),
+		s.EncodePoint(user.v),
+		s.EncodeScalar(user.w0),
+	)
+
+	user.tt = t.Bytes()
+	user.kMain = t.Sum()
+}
+
+// deriveKeys runs the rest of the RFC 9383 key schedule, K_main = Hash(TT) comes with the transcript:
+// K_confirmP || K_confirmV = KDF(nil, K_main, "ConfirmationKeys")
+// K_shared = KDF(nil, K_main, "SharedKey")
+func (user *Participant) deriveKeys() {
+	// confirmation keys are as long as the hash for HMAC, CMAC-AES-128 needs 16 bytes
+	kcLen := len(user.kMain)
+	if user.Suite.MACName == suite.CMACAES128 {
+		kcLen = 16
+	}
+
+	kc := user.kdf([]byte("ConfirmationKeys"), 2*kcLen)
+	user.kConfirmP, user.kConfirmV = kc[:kcLen], kc[kcLen:]
+	user.kShared = user.kdf([]byte("SharedKey"), len(user.kMain))
+}
+
+// kdf is HKDF with the suite's hash, no salt and K_main as input key material
+func (user *Participant) kdf(info []byte, length int) []byte {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(user.Suite.NewHash, user.kMain, nil, info), out); err != nil {
+		panic(err)
+	}
+
+	return out
+}
+
+// confirmation computes MAC(key, share)
+func (user *Participant) confirmation(key []byte, share *suite.Point) ([]byte, error) {
+	return user.Suite.Tag(key, user.Suite.EncodePoint(share))
+}
+
+// ownPoint is M for the prover and N for the verifier
+func (user *Participant) ownPoint() *suite.Point {
+	if user.Role == suite.Client {
+		return user.Suite.M
+	}
+	return user.Suite.N
+}
+
+func (user *Participant) peerPoint() *suite.Point {
+	if user.Role == suite.Client {
+		return user.Suite.N
+	}
+	return user.Suite.M
+}
+
+func (user *Participant) ownShare() []byte {
+	if user.Role == suite.Client {
+		return user.Suite.EncodePoint(user.ShareP)
+	}
+	return user.Suite.EncodePoint(user.ShareV)
+}
+
+func (user *Participant) peerShare() *suite.Point {
+	if user.Role == suite.Client {
+		return user.ShareV
+	}
+	return user.ShareP
+}
+
+func (user *Participant) ownConfirmKey() []byte {
+	if user.Role == suite.Client {
+		return user.kConfirmP
+	}
+	return user.kConfirmV
+}
+
+func (user *Participant) peerConfirmKey() []byte {
+	if user.Role == suite.Client {
+		return user.kConfirmV
+	}
+	return user.kConfirmP
+}
+
+// expectState returns a StateError unless the participant is in the given state
+func (user *Participant) expectState(op string, state spake2.State) error {
+	if user.state != state {
+		return &spake2.StateError{Op: op, State: user.state, Expected: []spake2.State{state}}
+	}
+
+	return nil
+}
+
+// parseMessage checks the message type and returns the payload
+func parseMessage(want byte, msg []byte) ([]byte, error) {
+	if len(msg) < 2 || msg[0] != want {
+		return nil, ErrUnexpectedMessage
+	}
+
+	return msg[1:], nil
+}
+
+var _ spake2.Handshake = (*Participant)(nil)
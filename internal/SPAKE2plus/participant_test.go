@@ -0,0 +1,152 @@
+package spake2plus_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	spake2plus "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2plus"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// vector holds one RFC 9383 Appendix C test vector, every value but the strings is hex encoded
+type vector struct {
+	Suite      suite.SuiteOptions
+	Context    string
+	IDProver   string
+	IDVerifier string
+	W0         string
+	W1         string
+	L          string
+	X          string
+	ShareP     string
+	Y          string
+	ShareV     string
+	Z          string
+	V          string
+	TT         string
+	KMain      string
+	KConfirmP  string
+	KConfirmV  string
+	ConfirmP   string // MAC(K_confirmP, shareV) sent by the prover
+	ConfirmV   string // MAC(K_confirmV, shareP) sent by the verifier
+	KShared    string
+}
+
+// vectors from RFC 9383 Appendix C, SPAKE2+-P256-SHA256-HKDF-SHA256-HMAC-SHA256
+var vectors = []vector{
+	{
+		Suite:      suite.P256,
+		Context:    "SPAKE2+-P256-SHA256-HKDF-SHA256-HMAC-SHA256 Test Vectors",
+		IDProver:   "client",
+		IDVerifier: "server",
+		W0:         "bb8e1bbcf3c48f62c08db243652ae55d3e5586053fca77102994f23ad95491b3",
+		W1:         "7e945f34d78785b8a3ef44d0df5a1a97d6b3b460409a345ca7830387a74b1dba",
+		L:          "04eb7c9db3d9a9eb1f8adab81b5794c1f13ae3e225efbe91ea487425854c7fc00f00bfedcbd09b2400142d40a14f2064ef31dfaa903b91d1faea7093d835966efd",
+		X:          "d1232c8e8693d02368976c174e2088851b8365d0d79a9eee709c6a05a2fad539",
+		ShareP:     "04ef3bd051bf78a2234ec0df197f7828060fe9856503579bb1733009042c15c0c1de127727f418b5966afadfdd95a6e4591d171056b333dab97a79c7193e341727",
+		Y:          "717a72348a182085109c8d3917d6c43d59b224dc6a7fc4f0483232fa6516d8b3",
+		ShareV:     "04c0f65da0d11927bdf5d560c69e1d7d939a05b0e88291887d679fcadea75810fb5cc1ca7494db39e82ff2f50665255d76173e09986ab46742c798a9a68437b048",
+		Z:          "04bbfce7dd7f277819c8da21544afb7964705569bdf12fb92aa388059408d50091a0c5f1d3127f56813b5337f9e4e67e2ca633117a4fbd559946ab474356c41839",
+		V:          "0458bf27c6bca011c9ce1930e8984a797a3419797b936629a5a937cf2f11c8b9514b82b993da8a46e664f23db7c01edc87faa530db01c2ee405230b18997f16b68",
+		TT:         "38000000000000005350414b45322b2d503235362d5348413235362d484b44462d5348413235362d484d41432d534841323536205465737420566563746f72730600000000000000636c69656e740600000000000000736572766572410000000000000004886e2f97ace46e55ba9dd7242579f2993b64e16ef3dcab95afd497333d8fa12f5ff355163e43ce224e0b0e65ff02ac8e5c7be09419c785e0ca547d55a12e2d20410000000000000004d8bbd6c639c62937b04d997f38c3770719c629d7014d49a24b4f98baa1292b4907d60aa6bfade45008a636337f5168c64d9bd36034808cd564490b1e656edbe7410000000000000004ef3bd051bf78a2234ec0df197f7828060fe9856503579bb1733009042c15c0c1de127727f418b5966afadfdd95a6e4591d171056b333dab97a79c7193e341727410000000000000004c0f65da0d11927bdf5d560c69e1d7d939a05b0e88291887d679fcadea75810fb5cc1ca7494db39e82ff2f50665255d76173e09986ab46742c798a9a68437b048410000000000000004bbfce7dd7f277819c8da21544afb7964705569bdf12fb92aa388059408d50091a0c5f1d3127f56813b5337f9e4e67e2ca633117a4fbd559946ab474356c4183941000000000000000458bf27c6bca011c9ce1930e8984a797a3419797b936629a5a937cf2f11c8b9514b82b993da8a46e664f23db7c01edc87faa530db01c2ee405230b18997f16b682000000000000000bb8e1bbcf3c48f62c08db243652ae55d3e5586053fca77102994f23ad95491b3",
+		KMain:      "4c59e1ccf2cfb961aa31bd9434478a1089b56cd11542f53d3576fb6c2a438a29",
+		KConfirmP:  "871ae3f7b78445e34438fb284504240239031c39d80ac23eb5ab9be5ad6db58a",
+		KConfirmV:  "ccd53c7c1fa37b64a462b40db8be101cedcf838950162902054e644b400f1680",
+		ConfirmP:   "926cc713504b9b4d76c9162ded04b5493e89109f6d89462cd33adc46fda27527",
+		ConfirmV:   "9747bcc4f8fe9f63defee53ac9b07876d907d55047e6ff2def2e7529089d3e68",
+		KShared:    "0c5f8ccd1413423a54f6c1fb26ff01534a87f893779c6e68666d772bfd91f3e7",
+	},
+}
+
+// expect compares got with the hex encoded want
+func expect(t *testing.T, name string, got []byte, want string) {
+	t.Helper()
+	if !bytes.Equal(got, paketest.MustHex(want)) {
+		t.Errorf("%s mismatch: got %x, want %s", name, got, want)
+	}
+}
+
+// TestVectors drives a prover and a verifier with the fixed scalars of each vector
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		t.Run(string(v.Suite), func(t *testing.T) {
+			s := suite.SelectECCSuite(v.Suite)
+			secrets := &spake2plus.Secrets{
+				W0: new(big.Int).SetBytes(paketest.MustHex(v.W0)),
+				W1: new(big.Int).SetBytes(paketest.MustHex(v.W1)),
+			}
+
+			record := secrets.Record(s, nil)
+			expect(t, "L", record.L, v.L)
+
+			prover, err := spake2plus.NewProver(v.IDProver, secrets, &spake2plus.SetUpParams{
+				Suite: v.Suite, Context: v.Context, OpponentIdentity: v.IDVerifier, Rand: bytes.NewReader(paketest.MustHex(v.X)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			verifier, err := spake2plus.NewVerifier(v.IDVerifier, record, &spake2plus.SetUpParams{
+				Suite: v.Suite, Context: v.Context, OpponentIdentity: v.IDProver, Rand: bytes.NewReader(paketest.MustHex(v.Y)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			shareP, err := prover.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect(t, "shareP", shareP[1:], v.ShareP)
+
+			shareV, err := verifier.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect(t, "shareV", shareV[1:], v.ShareV)
+
+			confirmP, err := prover.Respond(shareV)
+			if err != nil {
+				t.Fatal(err)
+			}
+			confirmV, err := verifier.Respond(shareP)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// nothing derived is handed out before the peer is confirmed
+			if _, err := verifier.KeySchedule(); err == nil {
+				t.Fatal("key schedule available before confirmation")
+			}
+
+			expect(t, "confirmP", confirmP[1:], v.ConfirmP)
+			expect(t, "confirmV", confirmV[1:], v.ConfirmV)
+
+			if _, err := prover.Finish(confirmV); err != nil {
+				t.Fatal("prover rejected confirmV: ", err)
+			}
+			if _, err := verifier.Finish(confirmP); err != nil {
+				t.Fatal("verifier rejected confirmP: ", err)
+			}
+
+			for _, p := range []struct {
+				name string
+				user *spake2plus.Participant
+			}{{"prover", prover}, {"verifier", verifier}} {
+				ks, err := p.user.KeySchedule()
+				if err != nil {
+					t.Fatal(err)
+				}
+				expect(t, "Z ("+p.name+")", ks.Z, v.Z)
+				expect(t, "V ("+p.name+")", ks.V, v.V)
+				expect(t, "TT ("+p.name+")", ks.TT, v.TT)
+				expect(t, "K_main ("+p.name+")", ks.KMain, v.KMain)
+				expect(t, "K_confirmP ("+p.name+")", ks.KConfirmP, v.KConfirmP)
+				expect(t, "K_confirmV ("+p.name+")", ks.KConfirmV, v.KConfirmV)
+				expect(t, "K_shared ("+p.name+")", ks.KShared, v.KShared)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package spake2plus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters used as the memory hard function of RFC 9383 section 3.2
+const (
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// Secrets are w0 and w1, what the prover derives from its password
+type Secrets struct {
+	W0 *big.Int
+	W1 *big.Int
+}
+
+// Record is the registration record (w0, L = w1*G) the verifier stores instead of the password
+type Record struct {
+	W0   []byte // big endian w0
+	L    []byte // SEC1 encoded w1*G
+	Salt []byte // salt of the memory hard function, handed to the prover at login
+}
+
+// DeriveSecrets computes w0 and w1 from the password as RFC 9383 defines:
+// w0s || w1s = PBKDF(len(pw) || pw || len(idProver) || idProver || len(idVerifier) || idVerifier)
+// where w0 = w0s mod p and w1 = w1s mod p
+func DeriveSecrets(s *suite.Suite, pw, idProver, idVerifier string, salt []byte) (*Secrets, error) {
+	var input []byte
+	for _, field := range []string{pw, idProver, idVerifier} {
+		input = binary.LittleEndian.AppendUint64(input, uint64(len(field)))
+		input = append(input, field...)
+	}
+
+	// each half is 64 bits longer than the order so the reduction mod p is close to uniform
+	half := (s.Curve.Params().N.BitLen()+7)/8 + 8
+	out, err := scrypt.Key(input, salt, scryptN, scryptR, scryptP, 2*half)
+	if err != nil {
+		return nil, err
+	}
+
+	order := s.Curve.Params().N
+	return &Secrets{
+		W0: new(big.Int).Mod(new(big.Int).SetBytes(out[:half]), order),
+		W1: new(big.Int).Mod(new(big.Int).SetBytes(out[half:]), order),
+	}, nil
+}
+
+// Record computes the registration record the verifier keeps for these secrets
+func (sec *Secrets) Record(s *suite.Suite, salt []byte) *Record {
+	return &Record{
+		W0:   s.EncodeScalar(sec.W0),
+		L:    s.EncodePoint(s.BaseMultiply(sec.W1)),
+		Salt: salt,
+	}
+}
+
+// decode parses the record for the given suite
+func (r *Record) decode(s *suite.Suite) (w0 *big.Int, l *suite.Point, err error) {
+	l, err = s.DecodePoint(r.L)
+	if err != nil {
+		return nil, nil, fmt.Errorf("spake2plus: invalid L in record: %w", err)
+	}
+
+	w0 = new(big.Int).SetBytes(r.W0)
+	if w0.Cmp(s.Curve.Params().N) >= 0 {
+		return nil, nil, fmt.Errorf("spake2plus: w0 in record is out of range")
+	}
+
+	return w0, l, nil
+}
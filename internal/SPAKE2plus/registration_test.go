@@ -0,0 +1,36 @@
+package spake2plus_test
+
+import (
+	"testing"
+
+	spake2plus "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2plus"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// TestDeriveSecrets pins w0 and w1 for a fixed password, identities and salt, and checks changing the
+// identities or the salt changes both of them
+func TestDeriveSecrets(t *testing.T) {
+	s := suite.NewP256Suite()
+	derive := func(idProver, idVerifier, salt string) *spake2plus.Secrets {
+		t.Helper()
+		sec, err := spake2plus.DeriveSecrets(s, "password", idProver, idVerifier, []byte(salt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sec
+	}
+
+	sec := derive("client", "server", "salt")
+	expect(t, "w0", s.EncodeScalar(sec.W0), "55600574de0b254513d10c96f159136b26d30ff35250085da37cc5b5a06220cc")
+	expect(t, "w1", s.EncodeScalar(sec.W1), "ad31caead07fc024bba213febfe9e343850681f6b36ad6a0bd9f243f8973902e")
+
+	for _, other := range []*spake2plus.Secrets{
+		derive("server", "client", "salt"), // identities swapped
+		derive("client", "", "salt"),
+		derive("client", "server", "pepper"),
+	} {
+		if other.W0.Cmp(sec.W0) == 0 || other.W1.Cmp(sec.W1) == 0 {
+			t.Fatalf("different identities or salt, same secrets: w0 %x, w1 %x", other.W0, other.W1)
+		}
+	}
+}
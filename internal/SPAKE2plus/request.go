@@ -0,0 +1,12 @@
+package spake2plus
+
+import (
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// HelloRequest starts a SPAKE2+ login, the prover's share and confirmation then travel
+// in the same SPAKE2PublickeyRequest and SPAKE2MACRequest as plain SPAKE2
+type HelloRequest struct {
+	Identity string
+	Suite    suite.SuiteOptions
+}
@@ -0,0 +1,9 @@
+package spake2plus
+
+type HelloResponse struct {
+	Identity  string
+	Suite     string
+	SessionID string // names the session in every later request
+	Context   string
+	Salt      []byte // salt the prover needs to derive w0 and w1 from its password
+}
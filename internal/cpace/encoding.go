@@ -0,0 +1,58 @@
+package cpace
+
+import "bytes"
+
+// prependLen prefixes data with its length as a LEB128 integer
+func prependLen(data []byte) []byte {
+	var out []byte
+	n := len(data)
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			out = append(out, b)
+			break
+		}
+		out = append(out, b|0x80)
+	}
+
+	return append(out, data...)
+}
+
+// lvCat concatenates the length prefixed fields
+func lvCat(fields ...[]byte) []byte {
+	var out []byte
+	for _, f := range fields {
+		out = append(out, prependLen(f)...)
+	}
+
+	return out
+}
+
+// oCat is the ordered concatenation used by the symmetric transcript, larger string first
+func oCat(a, b []byte) []byte {
+	if lexicographicallyLarger(a, b) {
+		return append(append([]byte("oc"), a...), b...)
+	}
+
+	return append(append([]byte("oc"), b...), a...)
+}
+
+// lexicographicallyLarger compares the common prefix first and the lengths when that is equal
+func lexicographicallyLarger(a, b []byte) bool {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+
+	if c := bytes.Compare(a[:n], b[:n]); c != 0 {
+		return c > 0
+	}
+
+	return len(a) > len(b)
+}
+
+// ChannelIdentifier builds CI from the identities of the initiator and the responder
+func ChannelIdentifier(initiator, responder string) []byte {
+	return lvCat([]byte(initiator), []byte(responder))
+}
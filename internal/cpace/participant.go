@@ -0,0 +1,276 @@
+// Package cpace implements the CPace balanced PAKE (draft-irtf-cfrg-cpace) on top of the suite package,
+// so it can be compared with SPAKE2 through the same handshake API and HTTP server.
+package cpace
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// message types, the first byte of every handshake message
+const (
+	msgShare   byte = 1 // Ya or Yb
+	msgConfirm byte = 2 // MAC over the sender's share and associated data
+)
+
+var (
+	// ErrInvalidShare is returned when the peer's share is not a valid point or leads to the identity
+	ErrInvalidShare = errors.New("cpace: invalid peer share")
+
+	// ErrConfirmationFailed is returned when the peer's confirmation doesn't match
+	ErrConfirmationFailed = errors.New("cpace: key confirmation failed")
+
+	// ErrUnexpectedMessage is returned when a handshake message is malformed or of the wrong type
+	ErrUnexpectedMessage = errors.New("cpace: unexpected handshake message")
+)
+
+// Participant runs one side of CPace. suite.Client is the initiator, suite.Server the responder
+// and suite.Symmetric uses the ordered transcript so neither side needs to know who started.
+// The secrets are unexported and overwritten by Close.
+type Participant struct {
+	Suite            *suite.Suite
+	Role             suite.Role
+	Identity         string
+	OpponentIdentity string
+	DSI              []byte       // domain separation identifier
+	Share            *suite.Point // y * g
+	PeerShare        *suite.Point
+	SID              []byte
+	AD               []byte // our associated data
+	PeerAD           []byte
+	Rand             io.Reader    // source of randomness, crypto/rand when nil
+	g                *suite.Point // generator calculated from PRS, CI and sid
+	y                *big.Int     // ephemeral scalar
+	k                []byte       // x coordinate of y * peer share
+	isk              []byte       // intermediate session key
+	state            spake2.State
+}
+
+type SetUpParams struct {
+	Suite            suite.SuiteOptions
+	PRS              []byte // password related string
+	CI               []byte // channel identifier, see ChannelIdentifier
+	SID              []byte // session identifier, should be fresh for every session
+	OpponentIdentity string
+	AD               []byte // our associated data
+	PeerAD           []byte // peer's associated data
+	Rand             io.Reader
+}
+
+// NewParticipant calculates the generator and returns a participant ready to Start
+func NewParticipant(role suite.Role, identity string, param *SetUpParams) (*Participant, error) {
+	switch role {
+	case suite.Server, suite.Client, suite.Symmetric:
+	default:
+		return nil, fmt.Errorf("cpace: unknown role %q", role)
+	}
+
+	s := suite.SelectECCSuite(param.Suite)
+	if s == nil {
+		return nil, fmt.Errorf("cpace: unknown suite %q", param.Suite)
+	}
+
+	user := &Participant{
+		Suite:            s,
+		Role:             role,
+		Identity:         identity,
+		OpponentIdentity: param.OpponentIdentity,
+		DSI:              []byte("CPaceP256_XMD:SHA-256_SSWU_NU_"),
+		SID:              param.SID,
+		AD:               param.AD,
+		PeerAD:           param.PeerAD,
+		Rand:             param.Rand,
+	}
+
+	g, err := user.CalculateGenerator(param.PRS, param.CI)
+	if err != nil {
+		return nil, err
+	}
+	user.g = g.Clone()
+
+	user.state = spake2.StateSetUp
+	return user, nil
+}
+
+// CalculateGenerator hashes PRS, CI and sid to the generator used for this session:
+// g = encode_to_curve(lv_cat(DSI, PRS, zero_bytes(len_zpad), CI, sid))
+func (user *Participant) CalculateGenerator(prs, ci []byte) (*suite.Point, error) {
+	// pad so PRS ends up in the first block of the hash
+	zpad := user.Suite.NewHash().BlockSize() - 1 - len(prependLen(prs)) - len(prependLen(user.DSI))
+	if zpad < 0 {
+		zpad = 0
+	}
+
+	genStr := lvCat(user.DSI, prs, make([]byte, zpad), ci, user.SID)
+	return user.Suite.EncodeToCurve(genStr, append(append([]byte{}, user.DSI...), "_DST"...))
+}
+
+// State returns the current handshake state of the participant
+func (user *Participant) State() spake2.State {
+	return user.state
+}
+
+// Start samples the ephemeral scalar y and returns Y = y * g
+func (user *Participant) Start() ([]byte, error) {
+	if err := user.expectState("Start", spake2.StateSetUp); err != nil {
+		return nil, err
+	}
+
+	random := user.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	// y in [1, order)
+	y, err := rand.Int(random, new(big.Int).Sub(user.Suite.Curve.Params().N, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	user.y = y.Add(y, big.NewInt(1))
+	user.Share = user.Suite.Multiply(user.g, user.y).Clone()
+
+	user.state = spake2.StateSent
+	return append([]byte{msgShare}, user.Suite.EncodePoint(user.Share)...), nil
+}
+
+// Respond computes K and ISK from the peer's share and returns our confirmation message
+func (user *Participant) Respond(peerMsg []byte) ([]byte, error) {
+	if err := user.expectState("Respond", spake2.StateSent); err != nil {
+		return nil, err
+	}
+
+	payload, err := parseMessage(msgShare, peerMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	// scalar_mult_vfy: the peer's share has to be a valid point and the result not the identity
+	peer, err := user.Suite.DecodePoint(payload)
+	if err != nil {
+		return nil, ErrInvalidShare
+	}
+
+	k := user.Suite.Multiply(peer, user.y)
+	if k.IsIdentity() {
+		return nil, ErrInvalidShare
+	}
+
+	user.PeerShare = peer
+	user.k = k.X.FillBytes(make([]byte, user.Suite.ByteLen()))
+	user.isk = user.deriveISK()
+
+	user.state = spake2.StateKeyDerived
+	confirmation, err := user.confirmation(user.Share, user.AD)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{msgConfirm}, confirmation...), nil
+}
+
+// Finish verifies the peer's confirmation and returns the session keyed with ISK
+func (user *Participant) Finish(peerMsg []byte) (*spake2.Session, error) {
+	if err := user.expectState("Finish", spake2.StateKeyDerived); err != nil {
+		return nil, err
+	}
+
+	payload, err := parseMessage(msgConfirm, peerMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Suite.VerifyTag(user.macKey(), lvCat(user.Suite.EncodePoint(user.PeerShare), user.PeerAD), payload) {
+		user.Close()
+		return nil, ErrConfirmationFailed
+	}
+
+	user.state = spake2.StateConfirmed
+	role, err := spake2.TrafficRole(user.Role, user.Suite.EncodePoint(user.Share), user.Suite.EncodePoint(user.PeerShare))
+	if err != nil {
+		return nil, err
+	}
+	return spake2.NewSession(user.Suite, role, user.Identity, user.OpponentIdentity, user.isk)
+}
+
+// Close overwrites every secret of the handshake, the Session handed out by Finish isn't affected
+func (user *Participant) Close() {
+	user.g.Wipe()
+	suite.WipeInt(user.y)
+	suite.Wipe(user.k)
+	suite.Wipe(user.isk)
+
+	user.g, user.y, user.k, user.isk = nil, nil, nil, nil
+	user.state = spake2.StateClosed
+}
+
+// deriveISK computes ISK = H(lv_cat(DSI || "_ISK", sid, K) || transcript)
+func (user *Participant) deriveISK() []byte {
+	h := user.Suite.NewHash()
+	h.Write(lvCat(append(append([]byte{}, user.DSI...), "_ISK"...), user.SID, user.k))
+	h.Write(user.transcript())
+
+	return h.Sum(nil)
+}
+
+// transcript is transcript_ir(Ya, ADa, Yb, ADb) for the initiator and the responder and
+// transcript_oc of both shares for symmetric participants
+func (user *Participant) transcript() []byte {
+	own := lvCat(user.Suite.EncodePoint(user.Share), user.AD)
+	peer := lvCat(user.Suite.EncodePoint(user.PeerShare), user.PeerAD)
+
+	switch user.Role {
+	case suite.Client:
+		// the client sent Ya
+		return append(own, peer...)
+	case suite.Server:
+		return append(peer, own...)
+	default:
+		return oCat(own, peer)
+	}
+}
+
+// macKey derives the key confirmation key from ISK
+func (user *Participant) macKey() []byte {
+	h := user.Suite.NewHash()
+	h.Write([]byte("CPaceMac"))
+	h.Write(user.isk)
+	key := h.Sum(nil)
+
+	// CMAC-AES-128 takes a 16 byte key
+	if user.Suite.MACName == suite.CMACAES128 {
+		key = key[:16]
+	}
+
+	return key
+}
+
+// confirmation computes MAC(mac_key, lv_cat(Y, AD)) over one side's share
+func (user *Participant) confirmation(share *suite.Point, ad []byte) ([]byte, error) {
+	return user.Suite.Tag(user.macKey(), lvCat(user.Suite.EncodePoint(share), ad))
+}
+
+// expectState returns a StateError unless the participant is in the given state
+func (user *Participant) expectState(op string, state spake2.State) error {
+	if user.state != state {
+		return &spake2.StateError{Op: op, State: user.state, Expected: []spake2.State{state}}
+	}
+
+	return nil
+}
+
+// parseMessage checks the message type and returns the payload
+func parseMessage(want byte, msg []byte) ([]byte, error) {
+	if len(msg) < 2 || msg[0] != want {
+		return nil, ErrUnexpectedMessage
+	}
+
+	return msg[1:], nil
+}
+
+var _ spake2.Handshake = (*Participant)(nil)
@@ -0,0 +1,41 @@
+package cpace_test
+
+import (
+	"testing"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/cpace"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// TestHandshake runs an initiator and a responder through the handshake API and checks they agree on ISK
+func TestHandshake(t *testing.T) {
+	ci := cpace.ChannelIdentifier("client", "server")
+	sid := []byte("test sid")
+
+	initiator, err := cpace.NewParticipant(suite.Client, "client", &cpace.SetUpParams{
+		Suite: suite.P256, PRS: []byte("password"), CI: ci, SID: sid, OpponentIdentity: "server",
+		AD: []byte("client"), PeerAD: []byte("server"), Rand: spake2.NewDeterministicReader([]byte("initiator")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responder, err := cpace.NewParticipant(suite.Server, "server", &cpace.SetUpParams{
+		Suite: suite.P256, PRS: []byte("password"), CI: ci, SID: sid, OpponentIdentity: "client",
+		AD: []byte("server"), PeerAD: []byte("client"), Rand: spake2.NewDeterministicReader([]byte("responder")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initiatorSession, responderSession, err := paketest.RunHandshake(initiator, responder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !paketest.SessionsAgree(initiatorSession, responderSession) {
+		t.Fatal("initiator and responder derived different ISK")
+	}
+}
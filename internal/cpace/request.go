@@ -0,0 +1,12 @@
+package cpace
+
+import (
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// HelloRequest starts a CPace login, Ya and the confirmation then travel
+// in the same SPAKE2PublickeyRequest and SPAKE2MACRequest as SPAKE2
+type HelloRequest struct {
+	Identity string
+	Suite    suite.SuiteOptions
+}
@@ -0,0 +1,8 @@
+package cpace
+
+type HelloResponse struct {
+	Identity  string
+	Suite     string
+	SessionID string // names the session in every later request
+	SID       []byte // session identifier picked by the server
+}
@@ -0,0 +1,141 @@
+package cpace
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// vector holds the inputs and outputs of a CPace test vector, everything but the strings hex encoded
+type vector struct {
+	Suite    suite.SuiteOptions
+	PRS      string
+	CI       string
+	SID      string
+	ADa, ADb string
+	Ya, Yb   string // the scalars ya and yb, big endian
+
+	G            string // the generator
+	ShareA       string // Ya = ya * g
+	ShareB       string // Yb = yb * g
+	K            string
+	TranscriptIR string
+	ISKIR        string
+	TranscriptOC string
+	ISKSY        string
+}
+
+// vectors uses the inputs of the draft-irtf-cfrg-cpace P-256 vector, CPaceP256_XMD:SHA-256_SSWU_NU_.
+// G is the draft's generator, the other outputs are pinned from this implementation.
+var vectors = []vector{
+	{
+		Suite: suite.P256,
+		PRS:   "Password",
+		CI:    "0a41696e69746961746f720a42726573706f6e646572",
+		SID:   "34b36454cab2e7842c389f7d88ecb7df",
+		ADa:   "ADa",
+		ADb:   "ADb",
+		Ya:    "c9e47ca5debd2285727af47e55f5b7763fa79719da428f800190cc6659b4eafb",
+		Yb:    "a0b768ba7555621d133012d1dee27a0013c1bcfddd675811df12771e44d77b10",
+
+		G:            "041b51433114e096c9d595f0955f5717a75169afb95557f4a6f51155035dee19c76887bce5c7c054fa1fe48a4a62c7fb96dc75e34259d2f72b8d41f31b8e586bcd",
+		ShareA:       "04ed4d514c6a3bcc07fda727ef2fd903a33a13b92b363da5d96a89e2210872adf90f399418b2ae2801bc5f4eac3f641e30f5fb63e24cc1f8d4da5190709d727477",
+		ShareB:       "04ac7f081f6963919a788a90d8b3e5b278c860ae13915f512418101e14384e3fad6e9e5893c3999cf01c2a419caa6a5a1f0b38c9104164b8383f291ebaf6163cf7",
+		K:            "e3993ce40585a3ebf0a8eb6dd746cf345f5e0838c517661916e3055be3a495e4",
+		TranscriptIR: "4104ed4d514c6a3bcc07fda727ef2fd903a33a13b92b363da5d96a89e2210872adf90f399418b2ae2801bc5f4eac3f641e30f5fb63e24cc1f8d4da5190709d727477034144614104ac7f081f6963919a788a90d8b3e5b278c860ae13915f512418101e14384e3fad6e9e5893c3999cf01c2a419caa6a5a1f0b38c9104164b8383f291ebaf6163cf703414462",
+		ISKIR:        "407f6daadd905cde86a00dbcdd134512a8e062051a19eb3f3c6c0d350d0d8c61",
+		TranscriptOC: "6f634104ed4d514c6a3bcc07fda727ef2fd903a33a13b92b363da5d96a89e2210872adf90f399418b2ae2801bc5f4eac3f641e30f5fb63e24cc1f8d4da5190709d727477034144614104ac7f081f6963919a788a90d8b3e5b278c860ae13915f512418101e14384e3fad6e9e5893c3999cf01c2a419caa6a5a1f0b38c9104164b8383f291ebaf6163cf703414462",
+		ISKSY:        "28719fade21151a748e61edfe6c8070f184183b4166158d3e087cd8241490a92",
+	},
+}
+
+// scalarReader returns randomness that makes Start pick y, which it draws as 1 + a value below order - 1
+func scalarReader(y string) *bytes.Reader {
+	v := new(big.Int).SetBytes(paketest.MustHex(y))
+	return bytes.NewReader(v.Sub(v, big.NewInt(1)).FillBytes(make([]byte, len(y)/2)))
+}
+
+// TestVectors runs each vector once as initiator and responder and once symmetrically, checking the
+// generator, the shares, K, the transcript and ISK on both sides
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		for _, roles := range [][2]suite.Role{{suite.Client, suite.Server}, {suite.Symmetric, suite.Symmetric}} {
+			t.Run(string(roles[0]), func(t *testing.T) {
+				expect := func(name string, got []byte, want string) {
+					t.Helper()
+					if !bytes.Equal(got, paketest.MustHex(want)) {
+						t.Fatalf("%s mismatch: got %x, want %s", name, got, want)
+					}
+				}
+				newParticipant := func(role suite.Role, y, ad, peerAD string) *Participant {
+					user, err := NewParticipant(role, "", &SetUpParams{
+						Suite: v.Suite, PRS: []byte(v.PRS), CI: paketest.MustHex(v.CI), SID: paketest.MustHex(v.SID),
+						AD: []byte(ad), PeerAD: []byte(peerAD), Rand: scalarReader(y),
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+					return user
+				}
+
+				a := newParticipant(roles[0], v.Ya, v.ADa, v.ADb)
+				b := newParticipant(roles[1], v.Yb, v.ADb, v.ADa)
+				expect("g", a.Suite.EncodePoint(a.g), v.G)
+
+				shareA, err := a.Start()
+				if err != nil {
+					t.Fatal(err)
+				}
+				expect("Ya", shareA[1:], v.ShareA)
+				shareB, err := b.Start()
+				if err != nil {
+					t.Fatal(err)
+				}
+				expect("Yb", shareB[1:], v.ShareB)
+
+				if _, err := a.Respond(shareB); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := b.Respond(shareA); err != nil {
+					t.Fatal(err)
+				}
+
+				transcript, isk := v.TranscriptIR, v.ISKIR
+				if roles[0] == suite.Symmetric {
+					transcript, isk = v.TranscriptOC, v.ISKSY
+				}
+				for _, user := range []*Participant{a, b} {
+					expect("K", user.k, v.K)
+					expect("transcript", user.transcript(), transcript)
+					expect("ISK", user.isk, isk)
+				}
+			})
+		}
+	}
+}
+
+// TestEncoding checks the length prefixed and ordered concatenations against the draft's examples
+func TestEncoding(t *testing.T) {
+	long := make([]byte, 128)
+	for i := range long {
+		long[i] = byte(i)
+	}
+
+	for _, c := range []struct {
+		name      string
+		got, want []byte
+	}{
+		{"prepend_len(\"\")", prependLen(nil), paketest.MustHex("00")},
+		{"prepend_len(\"1234\")", prependLen([]byte("1234")), paketest.MustHex("0431323334")},
+		{"prepend_len(128 bytes)", prependLen(long)[:2], paketest.MustHex("8001")},
+		{"lv_cat", lvCat([]byte("1234"), []byte("5"), nil, []byte("6789")), paketest.MustHex("04313233340135000436373839")},
+		{"o_cat", oCat([]byte("ABCD"), []byte("BCD")), paketest.MustHex("6f6342434441424344")},
+	} {
+		if !bytes.Equal(c.got, c.want) {
+			t.Errorf("%s: got %x, want %x", c.name, c.got, c.want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+// Package dragonfly implements the Dragonfly key exchange (RFC 7664) on top of the suite package,
+// with the password element derived by the SAE hash-to-element method instead of hunting and pecking,
+// so the time it takes doesn't depend on the password.
+package dragonfly
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+	"golang.org/x/crypto/hkdf"
+)
+
+// message types, the first byte of every handshake message
+const (
+	msgCommit  byte = 1 // scalar || Element
+	msgConfirm byte = 2 // MAC(kck, scalar || peer scalar || Element || peer Element)
+)
+
+var (
+	// ErrInvalidCommit is returned when the peer's scalar or element is out of range, or reflects ours
+	ErrInvalidCommit = errors.New("dragonfly: invalid peer commit")
+
+	// ErrConfirmationFailed is returned when the peer's confirm doesn't match
+	ErrConfirmationFailed = errors.New("dragonfly: key confirmation failed")
+
+	// ErrUnexpectedMessage is returned when a handshake message is malformed or of the wrong type
+	ErrUnexpectedMessage = errors.New("dragonfly: unexpected handshake message")
+)
+
+// Participant runs one side of Dragonfly. The exchange is symmetric, the role is only kept for the session.
+// The secrets are unexported and overwritten by Close.
+type Participant struct {
+	Suite            *suite.Suite
+	Role             suite.Role
+	Identity         string
+	OpponentIdentity string
+	Scalar           *big.Int // (private + mask) mod q
+	Element          *suite.Point
+	PeerScalar       *big.Int
+	PeerElement      *suite.Point
+	Rand             io.Reader    // source of randomness, crypto/rand when nil
+	pt               *suite.Point // password token, only depends on the password and SSID
+	pe               *suite.Point // password element bound to both identities
+	private          *big.Int
+	mask             *big.Int
+	confirmationKey  []byte // kck
+	masterKey        []byte // mk, keys the session
+	state            spake2.State
+}
+
+type SetUpParams struct {
+	Suite            suite.SuiteOptions
+	Pw               string
+	SSID             []byte // salt of the password token, the network name in SAE
+	OpponentIdentity string
+	Rand             io.Reader
+}
+
+// NewParticipant derives the password element and returns a participant ready to Start
+func NewParticipant(role suite.Role, identity string, param *SetUpParams) (*Participant, error) {
+	switch role {
+	case suite.Server, suite.Client, suite.Symmetric:
+	default:
+		return nil, fmt.Errorf("dragonfly: unknown role %q", role)
+	}
+
+	s := suite.SelectECCSuite(param.Suite)
+	if s == nil {
+		return nil, fmt.Errorf("dragonfly: unknown suite %q", param.Suite)
+	}
+
+	user := &Participant{
+		Suite:            s,
+		Role:             role,
+		Identity:         identity,
+		OpponentIdentity: param.OpponentIdentity,
+		Rand:             param.Rand,
+	}
+
+	user.pt = user.PasswordToken([]byte(param.Pw), param.SSID).Clone()
+	pe, err := user.PasswordElement(user.pt)
+	if err != nil {
+		return nil, err
+	}
+	user.pe = pe.Clone()
+
+	user.state = spake2.StateSetUp
+	return user, nil
+}
+
+// PasswordToken is the first half of SAE hash-to-element: two HKDF derived field elements mapped with
+// simplified SWU and added, PT = SSWU(u1) + SSWU(u2). It can be computed ahead of time and stored.
+func (user *Participant) PasswordToken(pw, ssid []byte) *suite.Point {
+	p := user.Suite.Curve.Params().P
+	seed := hkdf.Extract(user.Suite.NewHash, pw, ssid)
+
+	// olen(p) + floor(olen(p) / 2) bytes, so u is close to uniform mod p
+	length := user.Suite.ByteLen() + user.Suite.ByteLen()/2
+
+	pt := &suite.Point{}
+	for _, label := range []string{"SAE Hash to Element u1 P1", "SAE Hash to Element u2 P2"} {
+		value := make([]byte, length)
+		if _, err := io.ReadFull(hkdf.Expand(user.Suite.NewHash, seed, []byte(label)), value); err != nil {
+			panic(err)
+		}
+
+		u := new(big.Int).Mod(new(big.Int).SetBytes(value), p)
+		pt = user.Suite.Add(pt, user.Suite.MapToCurveSSWU(u))
+	}
+
+	return pt
+}
+
+// PasswordElement binds the token to both identities: PE = val * PT with
+// val = H(0^n, max(A, B) || min(A, B)) mod (q - 1) + 1
+func (user *Participant) PasswordElement(pt *suite.Point) (*suite.Point, error) {
+	if pt.IsIdentity() {
+		return nil, errors.New("dragonfly: password token is the identity")
+	}
+
+	a, b := []byte(user.Identity), []byte(user.OpponentIdentity)
+	if bytes.Compare(a, b) < 0 {
+		a, b = b, a
+	}
+
+	zeros := make([]byte, user.Suite.NewHash().Size())
+	val := new(big.Int).SetBytes(hkdf.Extract(user.Suite.NewHash, append(append([]byte{}, a...), b...), zeros))
+
+	q := user.Suite.Curve.Params().N
+	val.Mod(val, new(big.Int).Sub(q, big.NewInt(1)))
+	val.Add(val, big.NewInt(1))
+
+	return user.Suite.Multiply(pt, val), nil
+}
+
+// State returns the current handshake state of the participant
+func (user *Participant) State() spake2.State {
+	return user.state
+}
+
+// Start returns the commit: scalar = (private + mask) mod q and Element = -(mask * PE)
+func (user *Participant) Start() ([]byte, error) {
+	if err := user.expectState("Start", spake2.StateSetUp); err != nil {
+		return nil, err
+	}
+
+	q := user.Suite.Curve.Params().N
+
+	// private and mask in (1, q), redrawn until the scalar is too
+	for user.Scalar == nil || user.Scalar.Cmp(big.NewInt(2)) < 0 {
+		private, err := user.randomScalar()
+		if err != nil {
+			return nil, err
+		}
+		mask, err := user.randomScalar()
+		if err != nil {
+			return nil, err
+		}
+
+		user.private, user.mask = private, mask
+		user.Scalar = new(big.Int).Add(private, mask)
+		user.Scalar.Mod(user.Scalar, q)
+	}
+
+	user.Element = user.Suite.Multiply(user.pe, user.mask).Negate(user.Suite.Curve.Params().P)
+
+	user.state = spake2.StateSent
+	return append([]byte{msgCommit}, user.commit(user.Scalar, user.Element)...), nil
+}
+
+// Respond checks the peer's commit, derives the shared secret ss = private * (peer scalar * PE + peer Element)
+// and returns our confirm
+func (user *Participant) Respond(peerMsg []byte) ([]byte, error) {
+	if err := user.expectState("Respond", spake2.StateSent); err != nil {
+		return nil, err
+	}
+
+	payload, err := parseMessage(msgCommit, peerMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	scalarLen := len(user.Suite.EncodeScalar(user.Scalar))
+	if len(payload) <= scalarLen {
+		return nil, ErrUnexpectedMessage
+	}
+
+	q := user.Suite.Curve.Params().N
+	peerScalar := new(big.Int).SetBytes(payload[:scalarLen])
+	if peerScalar.Cmp(big.NewInt(1)) <= 0 || peerScalar.Cmp(q) >= 0 {
+		return nil, ErrInvalidCommit
+	}
+
+	peerElement, err := user.Suite.DecodePoint(payload[scalarLen:])
+	if err != nil {
+		return nil, ErrInvalidCommit
+	}
+
+	// a reflected commit would let an attacker finish the exchange without the password
+	if peerScalar.Cmp(user.Scalar) == 0 && peerElement.X.Cmp(user.Element.X) == 0 && peerElement.Y.Cmp(user.Element.Y) == 0 {
+		return nil, ErrInvalidCommit
+	}
+
+	ss := user.Suite.Add(user.Suite.Multiply(user.pe, peerScalar), peerElement)
+	if ss.IsIdentity() {
+		return nil, ErrInvalidCommit
+	}
+	ss = user.Suite.Multiply(ss, user.private)
+	if ss.IsIdentity() {
+		return nil, ErrInvalidCommit
+	}
+
+	user.PeerScalar, user.PeerElement = peerScalar, peerElement
+
+	k := ss.X.FillBytes(make([]byte, user.Suite.ByteLen()))
+	defer suite.Wipe(k)
+	user.deriveKeys(k)
+
+	user.state = spake2.StateKeyDerived
+	confirm, err := user.confirm(user.Scalar, user.PeerScalar, user.Element, user.PeerElement)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{msgConfirm}, confirm...), nil
+}
+
+// Finish verifies the peer's confirm and returns the session keyed with mk
+func (user *Participant) Finish(peerMsg []byte) (*spake2.Session, error) {
+	if err := user.expectState("Finish", spake2.StateKeyDerived); err != nil {
+		return nil, err
+	}
+
+	payload, err := parseMessage(msgConfirm, peerMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the peer MACs its own commit first
+	if !user.Suite.VerifyTag(user.kck(), user.confirmInput(user.PeerScalar, user.Scalar, user.PeerElement, user.Element), payload) {
+		user.Close()
+		return nil, ErrConfirmationFailed
+	}
+
+	user.state = spake2.StateConfirmed
+	role, err := spake2.TrafficRole(user.Role, user.Suite.EncodePoint(user.Element), user.Suite.EncodePoint(user.PeerElement))
+	if err != nil {
+		return nil, err
+	}
+	return spake2.NewSession(user.Suite, role, user.Identity, user.OpponentIdentity, user.masterKey)
+}
+
+// Close overwrites every secret of the handshake, the Session handed out by Finish isn't affected
+func (user *Participant) Close() {
+	user.pt.Wipe()
+	user.pe.Wipe()
+	suite.WipeInt(user.private)
+	suite.WipeInt(user.mask)
+	suite.Wipe(user.confirmationKey)
+	suite.Wipe(user.masterKey)
+
+	user.pt, user.pe, user.private, user.mask, user.confirmationKey, user.masterKey = nil, nil, nil, nil, nil, nil
+	user.state = spake2.StateClosed
+}
+
+// deriveKeys splits kck | mk = KDF(k, "Dragonfly Key Derivation", (scalar + peer scalar) mod q)
+func (user *Participant) deriveKeys(k []byte) {
+	n := user.Suite.NewHash().Size()
+
+	scalarSum := new(big.Int).Add(user.Scalar, user.PeerScalar)
+	scalarSum.Mod(scalarSum, user.Suite.Curve.Params().N)
+
+	keyseed := hkdf.Extract(user.Suite.NewHash, k, make([]byte, n))
+	defer suite.Wipe(keyseed)
+	info := append([]byte("Dragonfly Key Derivation"), user.Suite.EncodeScalar(scalarSum)...)
+
+	keys := make([]byte, 2*n)
+	if _, err := io.ReadFull(hkdf.Expand(user.Suite.NewHash, keyseed, info), keys); err != nil {
+		panic(err)
+	}
+
+	user.confirmationKey, user.masterKey = keys[:n], keys[n:]
+}
+
+// kck returns the confirmation key sized for the suite's MAC
+func (user *Participant) kck() []byte {
+	// CMAC-AES-128 takes a 16 byte key
+	if user.Suite.MACName == suite.CMACAES128 {
+		return user.confirmationKey[:16]
+	}
+
+	return user.confirmationKey
+}
+
+// confirm computes MAC(kck, scalar || peer scalar || Element || peer Element) from the sender's side
+func (user *Participant) confirm(scalar, peerScalar *big.Int, element, peerElement *suite.Point) ([]byte, error) {
+	return user.Suite.Tag(user.kck(), user.confirmInput(scalar, peerScalar, element, peerElement))
+}
+
+// confirmInput is the message covered by a confirm
+func (user *Participant) confirmInput(scalar, peerScalar *big.Int, element, peerElement *suite.Point) []byte {
+	out := user.Suite.EncodeScalar(scalar)
+	out = append(out, user.Suite.EncodeScalar(peerScalar)...)
+	out = append(out, user.Suite.EncodePoint(element)...)
+	return append(out, user.Suite.EncodePoint(peerElement)...)
+}
+
+// commit encodes a scalar and element
+func (user *Participant) commit(scalar *big.Int, element *suite.Point) []byte {
+	return append(user.Suite.EncodeScalar(scalar), user.Suite.EncodePoint(element)...)
+}
+
+// randomScalar picks a scalar in (1, q)
+func (user *Participant) randomScalar() (*big.Int, error) {
+	random := user.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	x, err := rand.Int(random, new(big.Int).Sub(user.Suite.Curve.Params().N, big.NewInt(2)))
+	if err != nil {
+		return nil, err
+	}
+
+	return x.Add(x, big.NewInt(2)), nil
+}
+
+// expectState returns a StateError unless the participant is in the given state
+func (user *Participant) expectState(op string, state spake2.State) error {
+	if user.state != state {
+		return &spake2.StateError{Op: op, State: user.state, Expected: []spake2.State{state}}
+	}
+
+	return nil
+}
+
+// parseMessage checks the message type and returns the payload
+func parseMessage(want byte, msg []byte) ([]byte, error) {
+	if len(msg) < 2 || msg[0] != want {
+		return nil, ErrUnexpectedMessage
+	}
+
+	return msg[1:], nil
+}
+
+var _ spake2.Handshake = (*Participant)(nil)
@@ -0,0 +1,50 @@
+package dragonfly_test
+
+import (
+	"errors"
+	"testing"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/dragonfly"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// TestHandshake runs Dragonfly with the same password, then checks a wrong password fails the confirm.
+// The SAE vectors use 802.11 MAC addresses and KDFs, so both sides are checked against each other instead.
+func TestHandshake(t *testing.T) {
+	for _, bobPw := range []string{"password", "wrong password"} {
+		t.Run(bobPw, func(t *testing.T) {
+			alice, err := dragonfly.NewParticipant(suite.Client, "alice", &dragonfly.SetUpParams{
+				Suite: suite.P256, Pw: "password", SSID: []byte("test"), OpponentIdentity: "bob",
+				Rand: spake2.NewDeterministicReader([]byte("alice")),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bob, err := dragonfly.NewParticipant(suite.Server, "bob", &dragonfly.SetUpParams{
+				Suite: suite.P256, Pw: bobPw, SSID: []byte("test"), OpponentIdentity: "alice",
+				Rand: spake2.NewDeterministicReader([]byte("bob")),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			aliceSession, bobSession, err := paketest.RunHandshake(alice, bob)
+			if bobPw != "password" {
+				if !errors.Is(err, dragonfly.ErrConfirmationFailed) {
+					t.Fatalf("wrong password: got %v, want %v", err, dragonfly.ErrConfirmationFailed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !paketest.SessionsAgree(aliceSession, bobSession) {
+				t.Fatal("peers derived different master keys")
+			}
+		})
+	}
+}
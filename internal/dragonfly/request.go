@@ -0,0 +1,12 @@
+package dragonfly
+
+import (
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// HelloRequest starts a Dragonfly login, the commit and confirm then travel
+// in the same SPAKE2PublickeyRequest and SPAKE2MACRequest as SPAKE2
+type HelloRequest struct {
+	Identity string
+	Suite    suite.SuiteOptions
+}
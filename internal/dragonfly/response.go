@@ -0,0 +1,8 @@
+package dragonfly
+
+type HelloResponse struct {
+	Identity  string
+	Suite     string
+	SessionID string // names the session in every later request
+	SSID      []byte // salt of the password token
+}
@@ -0,0 +1,343 @@
+// Package jpake implements J-PAKE over elliptic curves (RFC 8236) on top of the suite package,
+// so its two rounds can be compared with SPAKE2 through the same handshake API and HTTP server.
+package jpake
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/schnorr"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+	"golang.org/x/crypto/hkdf"
+)
+
+// message types, the first byte of every handshake message
+const (
+	msgRound1 byte = 1 // G1 and G2, each followed by its proof
+	msgRound2 byte = 2 // A and its proof
+)
+
+var (
+	// ErrInvalidPoint is returned when a point sent by the peer is invalid or leads to the identity
+	ErrInvalidPoint = errors.New("jpake: invalid peer point")
+
+	// ErrInvalidProof is returned when one of the peer's Schnorr proofs doesn't verify
+	ErrInvalidProof = errors.New("jpake: invalid zero-knowledge proof")
+
+	// ErrUnexpectedMessage is returned when a handshake message is malformed or of the wrong type
+	ErrUnexpectedMessage = errors.New("jpake: unexpected handshake message")
+)
+
+// Participant runs one side of J-PAKE. The protocol is symmetric, the role is only kept for the session.
+// J-PAKE gives implicit key authentication: with a wrong password both sides still finish,
+// but with different keys. The secrets are unexported and overwritten by Close.
+type Participant struct {
+	Suite            *suite.Suite
+	Role             suite.Role
+	Identity         string
+	OpponentIdentity string
+	G1               *suite.Point // x1 * G
+	G2               *suite.Point // x2 * G
+	G3               *suite.Point // peer's G1
+	G4               *suite.Point // peer's G2
+	Rand             io.Reader    // source of randomness, crypto/rand when nil
+	s                *big.Int     // password mapped to [1, n-1]
+	x1               *big.Int     // x1 in [1, n-1]
+	x2               *big.Int     // x2 in [1, n-1]
+	k                *suite.Point // (B - G4 * x2*s) * x2
+	sessionKey       []byte
+	state            spake2.State
+}
+
+type SetUpParams struct {
+	Suite            suite.SuiteOptions
+	Pw               string
+	OpponentIdentity string
+	Rand             io.Reader
+}
+
+// NewParticipant maps the password to s and returns a participant ready to Start
+func NewParticipant(role suite.Role, identity string, param *SetUpParams) (*Participant, error) {
+	switch role {
+	case suite.Server, suite.Client, suite.Symmetric:
+	default:
+		return nil, fmt.Errorf("jpake: unknown role %q", role)
+	}
+
+	s := suite.SelectECCSuite(param.Suite)
+	if s == nil {
+		return nil, fmt.Errorf("jpake: unknown suite %q", param.Suite)
+	}
+
+	// the proofs are bound to the signer's identity, so both sides need distinct ones
+	if identity == param.OpponentIdentity {
+		return nil, fmt.Errorf("jpake: identities must differ, both are %q", identity)
+	}
+
+	hash := s.Hash(param.Pw)
+	pw := new(big.Int).Mod(new(big.Int).SetBytes(hash[:]), s.Curve.Params().N)
+	if pw.Sign() == 0 {
+		return nil, errors.New("jpake: password maps to zero")
+	}
+
+	user := &Participant{
+		Suite:            s,
+		Role:             role,
+		Identity:         identity,
+		OpponentIdentity: param.OpponentIdentity,
+		s:                pw,
+		Rand:             param.Rand,
+		state:            spake2.StateSetUp,
+	}
+
+	return user, nil
+}
+
+// State returns the current handshake state of the participant
+func (user *Participant) State() spake2.State {
+	return user.state
+}
+
+// Start is round 1: G1 = x1*G and G2 = x2*G with a proof of knowledge of x1 and x2
+func (user *Participant) Start() ([]byte, error) {
+	if err := user.expectState("Start", spake2.StateSetUp); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if user.x1, err = user.randomScalar(); err != nil {
+		return nil, err
+	}
+	if user.x2, err = user.randomScalar(); err != nil {
+		return nil, err
+	}
+	user.G1 = user.Suite.BaseMultiply(user.x1)
+	user.G2 = user.Suite.BaseMultiply(user.x2)
+
+	g := user.generator()
+	zkp1, err := schnorr.Prove(user.Suite, g, user.x1, user.G1, user.Identity, nil, user.Rand)
+	if err != nil {
+		return nil, err
+	}
+	zkp2, err := schnorr.Prove(user.Suite, g, user.x2, user.G2, user.Identity, nil, user.Rand)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := []byte{msgRound1}
+	msg = append(msg, user.Suite.EncodePoint(user.G1)...)
+	msg = append(msg, zkp1.Bytes(user.Suite)...)
+	msg = append(msg, user.Suite.EncodePoint(user.G2)...)
+	msg = append(msg, zkp2.Bytes(user.Suite)...)
+
+	user.state = spake2.StateSent
+	return msg, nil
+}
+
+// Respond verifies the peer's round 1 and returns round 2: A = (G1+G3+G4) * x2*s with a proof of knowledge of x2*s
+func (user *Participant) Respond(peerMsg []byte) ([]byte, error) {
+	if err := user.expectState("Respond", spake2.StateSent); err != nil {
+		return nil, err
+	}
+
+	payload, err := parseMessage(msgRound1, peerMsg, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	g3, zkp3, err := user.decodeShare(payload[0])
+	if err != nil {
+		return nil, err
+	}
+	g4, zkp4, err := user.decodeShare(payload[1])
+	if err != nil {
+		return nil, err
+	}
+
+	g := user.generator()
+	if !schnorr.Verify(user.Suite, g, g3, zkp3, user.OpponentIdentity, nil) ||
+		!schnorr.Verify(user.Suite, g, g4, zkp4, user.OpponentIdentity, nil) {
+		return nil, ErrInvalidProof
+	}
+	user.G3, user.G4 = g3, g4
+
+	// the peer's x4 can't be zero, Verify already refuses the identity as G4
+	ga := user.Suite.Add(user.Suite.Add(user.G1, user.G3), user.G4)
+	if ga.IsIdentity() {
+		return nil, ErrInvalidPoint
+	}
+
+	x2s := user.x2s()
+	defer suite.WipeInt(x2s)
+
+	a := user.Suite.Multiply(ga, x2s)
+	zkp, err := schnorr.Prove(user.Suite, ga, x2s, a, user.Identity, nil, user.Rand)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := []byte{msgRound2}
+	msg = append(msg, user.Suite.EncodePoint(a)...)
+	msg = append(msg, zkp.Bytes(user.Suite)...)
+
+	user.state = spake2.StateKeyDerived
+	return msg, nil
+}
+
+// Finish verifies the peer's round 2, computes K = (B - G4 * x2*s) * x2 and returns the session keyed with KDF(K)
+func (user *Participant) Finish(peerMsg []byte) (*spake2.Session, error) {
+	if err := user.expectState("Finish", spake2.StateKeyDerived); err != nil {
+		return nil, err
+	}
+
+	payload, err := parseMessage(msgRound2, peerMsg, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	b, zkp, err := user.decodeShare(payload[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// the peer's generator is G1+G2+G3 from its point of view, G3+G1+G2 from ours
+	gb := user.Suite.Add(user.Suite.Add(user.G3, user.G1), user.G2)
+	if gb.IsIdentity() {
+		return nil, ErrInvalidPoint
+	}
+	if !schnorr.Verify(user.Suite, gb, b, zkp, user.OpponentIdentity, nil) {
+		user.Close()
+		return nil, ErrInvalidProof
+	}
+
+	x2s := user.x2s()
+	defer suite.WipeInt(x2s)
+
+	k := user.Suite.Subtract(b, user.Suite.Multiply(user.G4, x2s))
+	if k.IsIdentity() {
+		user.Close()
+		return nil, ErrInvalidPoint
+	}
+	user.k = user.Suite.Multiply(k, user.x2).Clone()
+
+	key, err := user.deriveKey()
+	if err != nil {
+		return nil, err
+	}
+	user.sessionKey = key
+
+	user.state = spake2.StateConfirmed
+	role, err := spake2.TrafficRole(user.Role, user.Suite.EncodePoint(user.G1), user.Suite.EncodePoint(user.G3))
+	if err != nil {
+		return nil, err
+	}
+	return spake2.NewSession(user.Suite, role, user.Identity, user.OpponentIdentity, user.sessionKey)
+}
+
+// Close overwrites every secret of the handshake, the Session handed out by Finish isn't affected
+func (user *Participant) Close() {
+	suite.WipeInt(user.s)
+	suite.WipeInt(user.x1)
+	suite.WipeInt(user.x2)
+	user.k.Wipe()
+	suite.Wipe(user.sessionKey)
+
+	user.s, user.x1, user.x2, user.k, user.sessionKey = nil, nil, nil, nil, nil
+	user.state = spake2.StateClosed
+}
+
+// deriveKey runs HKDF over the x coordinate of K
+func (user *Participant) deriveKey() ([]byte, error) {
+	ikm := user.k.X.FillBytes(make([]byte, user.Suite.ByteLen()))
+	defer suite.Wipe(ikm)
+
+	key := make([]byte, user.Suite.NewHash().Size())
+	if _, err := io.ReadFull(hkdf.New(user.Suite.NewHash, ikm, nil, []byte("JPAKE session key")), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// x2s returns x2 * s mod n
+func (user *Participant) x2s() *big.Int {
+	x2s := new(big.Int).Mul(user.x2, user.s)
+	return x2s.Mod(x2s, user.Suite.Curve.Params().N)
+}
+
+// generator returns the base point of the curve
+func (user *Participant) generator() *suite.Point {
+	params := user.Suite.Curve.Params()
+	return &suite.Point{X: params.Gx, Y: params.Gy}
+}
+
+// randomScalar picks a scalar in [1, n-1]
+func (user *Participant) randomScalar() (*big.Int, error) {
+	random := user.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	x, err := rand.Int(random, new(big.Int).Sub(user.Suite.Curve.Params().N, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+
+	return x.Add(x, big.NewInt(1)), nil
+}
+
+// decodeShare splits a point and the proof that follows it
+func (user *Participant) decodeShare(b []byte) (*suite.Point, *schnorr.Proof, error) {
+	pointLen := 1 + 2*user.Suite.ByteLen()
+	if len(b) != pointLen+schnorr.Size(user.Suite) {
+		return nil, nil, ErrUnexpectedMessage
+	}
+
+	p, err := user.Suite.DecodePoint(b[:pointLen])
+	if err != nil {
+		return nil, nil, ErrInvalidPoint
+	}
+
+	proof, err := schnorr.DecodeProof(user.Suite, b[pointLen:])
+	if err != nil {
+		return nil, nil, ErrInvalidProof
+	}
+
+	return p, proof, nil
+}
+
+// expectState returns a StateError unless the participant is in the given state
+func (user *Participant) expectState(op string, state spake2.State) error {
+	if user.state != state {
+		return &spake2.StateError{Op: op, State: user.state, Expected: []spake2.State{state}}
+	}
+
+	return nil
+}
+
+// parseMessage checks the message type and regroups the payload into n (point, proof) pairs.
+// Points are sent uncompressed, so every pair has the same length.
+func parseMessage(want byte, msg []byte, n int) ([][]byte, error) {
+	if len(msg) < 1 || msg[0] != want {
+		return nil, ErrUnexpectedMessage
+	}
+
+	payload := msg[1:]
+	if len(payload) == 0 || len(payload)%n != 0 {
+		return nil, ErrUnexpectedMessage
+	}
+
+	size := len(payload) / n
+	pairs := make([][]byte, n)
+	for i := range pairs {
+		pairs[i] = payload[i*size : (i+1)*size]
+	}
+
+	return pairs, nil
+}
+
+var _ spake2.Handshake = (*Participant)(nil)
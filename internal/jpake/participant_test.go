@@ -0,0 +1,42 @@
+package jpake_test
+
+import (
+	"testing"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/jpake"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// TestHandshake runs J-PAKE with the same password, where both sides must agree on the key, and with
+// different passwords, where they must not. RFC 8236 has no test vectors, and J-PAKE only gives
+// implicit authentication so both outcomes are checked.
+func TestHandshake(t *testing.T) {
+	for _, bobPw := range []string{"password", "wrong password"} {
+		t.Run(bobPw, func(t *testing.T) {
+			alice, err := jpake.NewParticipant(suite.Client, "alice", &jpake.SetUpParams{
+				Suite: suite.P256, Pw: "password", OpponentIdentity: "bob", Rand: spake2.NewDeterministicReader([]byte("alice")),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bob, err := jpake.NewParticipant(suite.Server, "bob", &jpake.SetUpParams{
+				Suite: suite.P256, Pw: bobPw, OpponentIdentity: "alice", Rand: spake2.NewDeterministicReader([]byte("bob")),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			aliceSession, bobSession, err := paketest.RunHandshake(alice, bob)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if agree := paketest.SessionsAgree(aliceSession, bobSession); agree != (bobPw == "password") {
+				t.Fatalf("key agreement with password %q: got %v", bobPw, agree)
+			}
+		})
+	}
+}
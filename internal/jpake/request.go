@@ -0,0 +1,12 @@
+package jpake
+
+import (
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// HelloRequest starts a J-PAKE login, round 1 and round 2 then travel
+// in the same SPAKE2PublickeyRequest and SPAKE2MACRequest as SPAKE2
+type HelloRequest struct {
+	Identity string
+	Suite    suite.SuiteOptions
+}
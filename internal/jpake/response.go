@@ -0,0 +1,7 @@
+package jpake
+
+type HelloResponse struct {
+	Identity  string
+	Suite     string
+	SessionID string // names the session in every later request
+}
@@ -0,0 +1,277 @@
+package opaque
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/oprf"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// Client runs the client side of either a registration or a login, a client is good for one of them.
+// The password and the secrets of the exchange are unexported and overwritten by Close.
+type Client struct {
+	Suite          *suite.Suite
+	Identity       string
+	ServerIdentity string
+	Context        []byte
+	KSF            KSF
+	Rand           io.Reader
+	password       []byte
+	blind          *big.Int
+	clientSecret   *big.Int // ephemeral 3DH key
+	exportKey      []byte   // application key only the client knows, set once registered or logged in
+	ke1            []byte
+	state          spake2.State
+}
+
+// NewClient returns a client ready to register or log in
+func NewClient(identity string, param *SetUpParams) (*Client, error) {
+	s := suite.SelectECCSuite(param.Suite)
+	if s == nil {
+		return nil, fmt.Errorf("opaque: unknown suite %q", param.Suite)
+	}
+
+	ksf := param.KSF
+	if ksf == nil {
+		ksf = ScryptKSF
+	}
+
+	random := param.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	return &Client{
+		Suite:          s,
+		Identity:       identity,
+		ServerIdentity: param.OpponentIdentity,
+		Context:        param.Context,
+		KSF:            ksf,
+		Rand:           random,
+		password:       []byte(param.Pw),
+		state:          spake2.StateSetUp,
+	}, nil
+}
+
+// State returns the current state of the client
+func (c *Client) State() spake2.State {
+	return c.state
+}
+
+// RegistrationRequest blinds the password, the result goes to the server's RegistrationResponse
+func (c *Client) RegistrationRequest() ([]byte, error) {
+	if err := c.expectState("RegistrationRequest", spake2.StateSetUp); err != nil {
+		return nil, err
+	}
+
+	blind, blinded, err := oprf.Blind(c.Suite, c.password, c.Rand)
+	if err != nil {
+		return nil, err
+	}
+	c.blind = blind
+
+	c.state = spake2.StateSent
+	return oprf.SerializeElement(c.Suite, blinded), nil
+}
+
+// FinalizeRegistration builds the envelope from the server's response and returns the record to upload
+func (c *Client) FinalizeRegistration(response []byte) ([]byte, error) {
+	if err := c.expectState("FinalizeRegistration", spake2.StateSent); err != nil {
+		return nil, err
+	}
+	if c.ke1 != nil {
+		return nil, &spake2.StateError{Op: "FinalizeRegistration", State: c.state, Expected: []spake2.State{spake2.StateSent}, Reason: "login in progress"}
+	}
+
+	noe := 1 + c.Suite.ByteLen()
+	if len(response) != 2*noe {
+		return nil, ErrUnexpectedMessage
+	}
+
+	evaluated, err := oprf.DeserializeElement(c.Suite, response[:noe])
+	if err != nil {
+		return nil, err
+	}
+	serverPublicKey := response[noe:]
+	if _, err := c.Suite.DecodePoint(serverPublicKey); err != nil {
+		return nil, ErrUnexpectedMessage
+	}
+
+	randomizedPassword, err := c.randomizedPassword(evaluated)
+	if err != nil {
+		return nil, err
+	}
+
+	record, exportKey, err := storeEnvelope(c.Suite, randomizedPassword, serverPublicKey, []byte(c.ServerIdentity), []byte(c.Identity), c.Rand)
+	if err != nil {
+		return nil, err
+	}
+	c.exportKey = exportKey
+
+	c.state = spake2.StateClosed
+	return record.Bytes(), nil
+}
+
+// Start returns KE1: the blinded password, a nonce and an ephemeral key share
+func (c *Client) Start() ([]byte, error) {
+	if err := c.expectState("Start", spake2.StateSetUp); err != nil {
+		return nil, err
+	}
+
+	blind, blinded, err := oprf.Blind(c.Suite, c.password, c.Rand)
+	if err != nil {
+		return nil, err
+	}
+	c.blind = blind
+
+	nonce, err := randomBytes(c.Rand, Nn)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := randomBytes(c.Rand, Nseed)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, keyshare, err := deriveDiffieHellmanKeyPair(c.Suite, seed)
+	if err != nil {
+		return nil, err
+	}
+	c.clientSecret = secret
+
+	c.ke1 = oprf.SerializeElement(c.Suite, blinded)
+	c.ke1 = append(c.ke1, nonce...)
+	c.ke1 = append(c.ke1, c.Suite.EncodePointCompressed(keyshare)...)
+
+	c.state = spake2.StateSent
+	return c.ke1, nil
+}
+
+// Finish recovers the credentials from KE2, authenticates the server and returns KE3 with the session
+func (c *Client) Finish(ke2 []byte) ([]byte, *spake2.Session, error) {
+	if err := c.expectState("Finish", spake2.StateSent); err != nil {
+		return nil, nil, err
+	}
+	if c.ke1 == nil {
+		return nil, nil, &spake2.StateError{Op: "Finish", State: c.state, Expected: []spake2.State{spake2.StateSent}, Reason: "registration in progress"}
+	}
+
+	noe, npk, nm := 1+c.Suite.ByteLen(), 1+c.Suite.ByteLen(), c.Suite.NewHash().Size()
+	credentialResponseLen := noe + Nn + npk + Nn + nm
+	if len(ke2) != credentialResponseLen+Nn+npk+nm {
+		return nil, nil, ErrUnexpectedMessage
+	}
+
+	credentialResponse := ke2[:credentialResponseLen]
+	serverNonce := ke2[credentialResponseLen : credentialResponseLen+Nn]
+	serverKeyshareBytes := ke2[credentialResponseLen+Nn : credentialResponseLen+Nn+npk]
+	serverMAC := ke2[credentialResponseLen+Nn+npk:]
+	maskingNonce := credentialResponse[noe : noe+Nn]
+	maskedResponse := credentialResponse[noe+Nn:]
+
+	// check both points of KE2 before the KSF runs on it, a malformed KE2 costs the client nothing
+	evaluated, err := oprf.DeserializeElement(c.Suite, credentialResponse[:noe])
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	serverKeyshare, err := c.Suite.DecodePoint(serverKeyshareBytes)
+	if err != nil {
+		c.Close()
+		return nil, nil, ErrUnexpectedMessage
+	}
+
+	randomizedPassword, err := c.randomizedPassword(evaluated)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// unmask the server's public key and the envelope
+	maskingKey := expand(c.Suite, randomizedPassword, []byte("MaskingKey"), c.Suite.NewHash().Size())
+	pad := expand(c.Suite, maskingKey, append(append([]byte{}, maskingNonce...), "CredentialResponsePad"...), len(maskedResponse))
+	unmasked := xor(pad, maskedResponse)
+	serverPublicKeyBytes, envelope := unmasked[:npk], unmasked[npk:]
+
+	serverPublicKey, err := c.Suite.DecodePoint(serverPublicKeyBytes)
+	if err != nil {
+		c.Close()
+		return nil, nil, ErrEnvelopeRecovery
+	}
+
+	clientPrivateKey, serverID, clientID, exportKey, err := recoverEnvelope(c.Suite, randomizedPassword, serverPublicKeyBytes, envelope, []byte(c.ServerIdentity), []byte(c.Identity))
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	defer suite.WipeInt(clientPrivateKey)
+
+	// 3DH from the client's side
+	ikm := c.Suite.EncodePointCompressed(c.Suite.Multiply(serverKeyshare, c.clientSecret))
+	ikm = append(ikm, c.Suite.EncodePointCompressed(c.Suite.Multiply(serverPublicKey, c.clientSecret))...)
+	ikm = append(ikm, c.Suite.EncodePointCompressed(c.Suite.Multiply(serverKeyshare, clientPrivateKey))...)
+
+	pre := preamble(c.Context, clientID, c.ke1, serverID, credentialResponse, serverNonce, serverKeyshareBytes)
+	km2, km3, sessionKey := deriveKeys(c.Suite, ikm, pre)
+
+	if !hmac.Equal(mac(c.Suite, km2, hash(c.Suite, pre)), serverMAC) {
+		c.Close()
+		return nil, nil, ErrServerAuthentication
+	}
+
+	session, err := spake2.NewSession(c.Suite, suite.Client, c.Identity, c.ServerIdentity, sessionKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientMAC := mac(c.Suite, km3, hash(c.Suite, append(pre, serverMAC...)))
+	c.exportKey = exportKey
+
+	c.state = spake2.StateConfirmed
+	return clientMAC, session, nil
+}
+
+// ExportKey returns a copy of the export key, available once registered or logged in until Close
+func (c *Client) ExportKey() []byte {
+	return bytes.Clone(c.exportKey)
+}
+
+// Close overwrites the password, the OPRF blind, the 3DH key and the export key
+func (c *Client) Close() {
+	suite.Wipe(c.password)
+	suite.WipeInt(c.blind)
+	suite.WipeInt(c.clientSecret)
+	suite.Wipe(c.exportKey)
+
+	c.password, c.blind, c.clientSecret, c.exportKey = nil, nil, nil, nil
+	c.state = spake2.StateClosed
+}
+
+// randomizedPassword unblinds the OPRF output and stretches it
+func (c *Client) randomizedPassword(evaluated *suite.Point) ([]byte, error) {
+	oprfOutput, err := oprf.Finalize(c.Suite, c.password, c.blind, evaluated)
+	if err != nil {
+		return nil, err
+	}
+
+	stretched, err := c.KSF(oprfOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	return extract(c.Suite, nil, append(oprfOutput, stretched...)), nil
+}
+
+// expectState returns a StateError unless the client is in the given state
+func (c *Client) expectState(op string, state spake2.State) error {
+	if c.state != state {
+		return &spake2.StateError{Op: op, State: c.state, Expected: []spake2.State{state}}
+	}
+
+	return nil
+}
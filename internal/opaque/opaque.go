@@ -0,0 +1,181 @@
+// Package opaque implements the OPAQUE augmented PAKE (RFC 9807) with 3DH on top of the oprf and suite packages.
+// The server never sees the password and only stores an envelope the client opens with it. A stolen record
+// alone can't be run against a dictionary, but together with the server's OPRF seed it can: each guess then
+// costs one OPRF evaluation and the key stretching function, which is why the server keys must stay secret.
+package opaque
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/oprf"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Nn and Nseed are the nonce and seed sizes fixed by RFC 9807
+const (
+	Nn    = 32
+	Nseed = 32
+)
+
+var (
+	// ErrEnvelopeRecovery is returned when the envelope doesn't open, usually a wrong password
+	ErrEnvelopeRecovery = errors.New("opaque: envelope recovery failed")
+
+	// ErrServerAuthentication is returned by the client when the server's MAC doesn't match
+	ErrServerAuthentication = errors.New("opaque: server authentication failed")
+
+	// ErrClientAuthentication is returned by the server when the client's MAC doesn't match
+	ErrClientAuthentication = errors.New("opaque: client authentication failed")
+
+	// ErrUnexpectedMessage is returned when a message is malformed
+	ErrUnexpectedMessage = errors.New("opaque: unexpected message")
+)
+
+// KSF is the key stretching function hardening the OPRF output against offline guessing
+type KSF func(msg []byte) ([]byte, error)
+
+// IdentityKSF skips stretching, RFC 9807 only allows it for test vectors
+func IdentityKSF(msg []byte) ([]byte, error) {
+	return msg, nil
+}
+
+// ScryptKSF stretches with scrypt, using the same cost as the SPAKE2+ registration
+func ScryptKSF(msg []byte) ([]byte, error) {
+	return scrypt.Key(msg, nil, 32768, 8, 1, len(msg))
+}
+
+type SetUpParams struct {
+	Suite            suite.SuiteOptions
+	Pw               string // the client's password, unused by the server
+	Context          []byte // bound into the preamble, both sides must agree on it
+	OpponentIdentity string
+	KSF              KSF       // ScryptKSF when nil
+	Rand             io.Reader // source of randomness, crypto/rand when nil
+}
+
+// extract is HKDF-Extract with the suite's hash
+func extract(s *suite.Suite, salt, ikm []byte) []byte {
+	return hkdf.Extract(s.NewHash, ikm, salt)
+}
+
+// expand is HKDF-Expand with the suite's hash
+func expand(s *suite.Suite, prk, info []byte, length int) []byte {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(s.NewHash, prk, info), out); err != nil {
+		// only happens when asking for more than 255 hash blocks
+		panic(err)
+	}
+
+	return out
+}
+
+// expandLabel is Expand-Label of RFC 9807 section 6.4.2
+func expandLabel(s *suite.Suite, secret []byte, label string, context []byte, length int) []byte {
+	label = "OPAQUE-" + label
+
+	customLabel := binary.BigEndian.AppendUint16(nil, uint16(length))
+	customLabel = append(customLabel, byte(len(label)))
+	customLabel = append(customLabel, label...)
+	customLabel = append(customLabel, byte(len(context)))
+	customLabel = append(customLabel, context...)
+
+	return expand(s, secret, customLabel, length)
+}
+
+// mac is HMAC with the suite's hash, OPAQUE fixes the MAC whatever the suite uses for SPAKE2
+func mac(s *suite.Suite, key, msg []byte) []byte {
+	m := hmac.New(s.NewHash, key)
+	m.Write(msg)
+
+	return m.Sum(nil)
+}
+
+// hash returns H(msg)
+func hash(s *suite.Suite, msg []byte) []byte {
+	h := s.NewHash()
+	h.Write(msg)
+
+	return h.Sum(nil)
+}
+
+// randomBytes reads n bytes from random
+func randomBytes(random io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(random, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// deriveDiffieHellmanKeyPair derives the AKE key pair from a seed
+func deriveDiffieHellmanKeyPair(s *suite.Suite, seed []byte) (*big.Int, *suite.Point, error) {
+	return oprf.DeriveKeyPair(s, seed, []byte("OPAQUE-DeriveDiffieHellmanKeyPair"))
+}
+
+// cleartextCredentials serializes the credentials authenticated by the envelope, the identities
+// default to the public keys when empty
+func cleartextCredentials(serverPublicKey, clientPublicKey []byte, serverIdentity, clientIdentity []byte) (server, client, serialized []byte) {
+	if len(serverIdentity) == 0 {
+		serverIdentity = serverPublicKey
+	}
+	if len(clientIdentity) == 0 {
+		clientIdentity = clientPublicKey
+	}
+
+	serialized = append([]byte{}, serverPublicKey...)
+	serialized = append(serialized, lengthPrefixed(serverIdentity)...)
+	serialized = append(serialized, lengthPrefixed(clientIdentity)...)
+
+	return serverIdentity, clientIdentity, serialized
+}
+
+// preamble builds the 3DH preamble both sides MAC
+func preamble(context, clientIdentity, ke1, serverIdentity, credentialResponse, serverNonce, serverKeyshare []byte) []byte {
+	out := []byte("OPAQUEv1-")
+	out = append(out, lengthPrefixed(context)...)
+	out = append(out, lengthPrefixed(clientIdentity)...)
+	out = append(out, ke1...)
+	out = append(out, lengthPrefixed(serverIdentity)...)
+	out = append(out, credentialResponse...)
+	out = append(out, serverNonce...)
+	out = append(out, serverKeyshare...)
+
+	return out
+}
+
+// deriveKeys returns Km2, Km3 and the session key from the 3DH input and preamble
+func deriveKeys(s *suite.Suite, ikm, preamble []byte) (km2, km3, sessionKey []byte) {
+	nx := s.NewHash().Size()
+	prk := extract(s, nil, ikm)
+	transcriptHash := hash(s, preamble)
+
+	handshakeSecret := expandLabel(s, prk, "HandshakeSecret", transcriptHash, nx)
+	sessionKey = expandLabel(s, prk, "SessionKey", transcriptHash, nx)
+	km2 = expandLabel(s, handshakeSecret, "ServerMAC", nil, nx)
+	km3 = expandLabel(s, handshakeSecret, "ClientMAC", nil, nx)
+
+	return km2, km3, sessionKey
+}
+
+// lengthPrefixed returns I2OSP(len(b), 2) || b
+func lengthPrefixed(b []byte) []byte {
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(b)))
+	return append(out, b...)
+}
+
+// xor returns a ^ b, both of the same length
+func xor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range out {
+		out[i] = a[i] ^ b[i]
+	}
+
+	return out
+}
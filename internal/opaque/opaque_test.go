@@ -0,0 +1,190 @@
+package opaque_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/opaque"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// TestRegisterAndLogin registers a client, logs it in and checks both sides end up with the same
+// session, then makes sure a wrong password can't open the envelope
+func TestRegisterAndLogin(t *testing.T) {
+	s := suite.NewP256Suite()
+	keys, err := opaque.GenerateServerKeys(s, spake2.NewDeterministicReader([]byte("server keys")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newClient := func(pw string) *opaque.Client {
+		client, err := opaque.NewClient("client", &opaque.SetUpParams{
+			Suite: suite.P256, Pw: pw, Context: []byte("test"), OpponentIdentity: "server",
+			KSF: opaque.IdentityKSF, Rand: spake2.NewDeterministicReader([]byte("client " + pw)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	newServer := func() *opaque.Server {
+		server, err := opaque.NewServer("server", keys, &opaque.SetUpParams{
+			Suite: suite.P256, Context: []byte("test"), OpponentIdentity: "client",
+			Rand: spake2.NewDeterministicReader([]byte("server")),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return server
+	}
+
+	// registration
+	client, server := newClient("password"), newServer()
+
+	request, err := client.RegistrationRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := server.RegistrationResponse(request, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := client.FinalizeRegistration(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := opaque.DecodeRecord(s, upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registrationExportKey := client.ExportKey()
+
+	// login, once with the right password and once with a wrong one
+	for _, pw := range []string{"password", "wrong password"} {
+		t.Run(pw, func(t *testing.T) {
+			client, server := newClient(pw), newServer()
+
+			ke1, err := client.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ke2, err := server.Respond(record, "client", ke1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ke3, clientSession, err := client.Finish(ke2)
+			if pw != "password" {
+				if !errors.Is(err, opaque.ErrEnvelopeRecovery) {
+					t.Fatalf("login with a wrong password: got %v, want %v", err, opaque.ErrEnvelopeRecovery)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serverSession, err := server.Finish(ke3)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !paketest.SessionsAgree(clientSession, serverSession) {
+				t.Fatal("client and server derived different session keys")
+			}
+			if !bytes.Equal(client.ExportKey(), registrationExportKey) {
+				t.Fatal("login and registration export keys differ")
+			}
+		})
+	}
+}
+
+// TestFakeRecord logs in a client that never registered against the fake record and checks the login
+// fails the way a wrong password does, and that the record doesn't change between logins
+func TestFakeRecord(t *testing.T) {
+	s := suite.NewP256Suite()
+	keys, err := opaque.GenerateServerKeys(s, spake2.NewDeterministicReader([]byte("server keys")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := keys.FakeRecord(s, "nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := keys.FakeRecord(s, "nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(record.Bytes(), again.Bytes()) {
+		t.Fatal("the fake record of an identity changed between logins")
+	}
+	if _, err := opaque.DecodeRecord(s, record.Bytes()); err != nil {
+		t.Fatalf("the fake record doesn't decode like a real one: %v", err)
+	}
+
+	client, err := opaque.NewClient("nobody", &opaque.SetUpParams{
+		Suite: suite.P256, Pw: "password", Context: []byte("test"), OpponentIdentity: "server", KSF: opaque.IdentityKSF,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := opaque.NewServer("server", keys, &opaque.SetUpParams{
+		Suite: suite.P256, Context: []byte("test"), OpponentIdentity: "nobody",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke1, err := client.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ke2, err := server.Respond(record, "nobody", ke1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.Finish(ke2); !errors.Is(err, opaque.ErrEnvelopeRecovery) {
+		t.Fatalf("login against a fake record: got %v, want %v", err, opaque.ErrEnvelopeRecovery)
+	}
+}
+
+// TestMalformedKE2 hands the client a KE2 whose server keyshare isn't a point and checks it's refused
+// before the password is stretched, and that the client is closed
+func TestMalformedKE2(t *testing.T) {
+	s := suite.NewP256Suite()
+	stretched := false
+	client, err := opaque.NewClient("client", &opaque.SetUpParams{
+		Suite: suite.P256, Pw: "password", Context: []byte("test"), OpponentIdentity: "server",
+		KSF: func(msg []byte) ([]byte, error) {
+			stretched = true
+			return opaque.IdentityKSF(msg)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke1, err := client.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a valid evaluated element, borrowed from KE1, and zeros for everything else
+	noe, npk, nm := 1+s.ByteLen(), 1+s.ByteLen(), s.NewHash().Size()
+	ke2 := make([]byte, noe+opaque.Nn+npk+opaque.Nn+nm+opaque.Nn+npk+nm)
+	copy(ke2, ke1[:noe])
+
+	if _, _, err := client.Finish(ke2); !errors.Is(err, opaque.ErrUnexpectedMessage) {
+		t.Fatalf("KE2 with a malformed keyshare: got %v, want %v", err, opaque.ErrUnexpectedMessage)
+	}
+	if stretched {
+		t.Fatal("the password was stretched for a malformed KE2")
+	}
+	if client.State() != spake2.StateClosed {
+		t.Fatalf("client left in state %v after a malformed KE2", client.State())
+	}
+}
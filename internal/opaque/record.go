@@ -0,0 +1,174 @@
+package opaque
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// Record is what the server keeps for every registered client
+type Record struct {
+	ClientPublicKey []byte
+	MaskingKey      []byte // hides the envelope and server key from whoever isn't the client
+	Envelope        []byte // envelope nonce || auth tag
+}
+
+// Bytes encodes the record as it's uploaded at the end of the registration
+func (r *Record) Bytes() []byte {
+	out := append([]byte{}, r.ClientPublicKey...)
+	out = append(out, r.MaskingKey...)
+	return append(out, r.Envelope...)
+}
+
+// DecodeRecord parses a record uploaded by the client and checks its public key
+func DecodeRecord(s *suite.Suite, b []byte) (*Record, error) {
+	npk, nh := 1+s.ByteLen(), s.NewHash().Size()
+	if len(b) != npk+nh+Nn+nh {
+		return nil, ErrUnexpectedMessage
+	}
+
+	if _, err := s.DecodePoint(b[:npk]); err != nil {
+		return nil, ErrUnexpectedMessage
+	}
+
+	return &Record{
+		ClientPublicKey: append([]byte{}, b[:npk]...),
+		MaskingKey:      append([]byte{}, b[npk:npk+nh]...),
+		Envelope:        append([]byte{}, b[npk+nh:]...),
+	}, nil
+}
+
+// Store is the server side envelope store, a record per credential identifier
+type Store struct {
+	mu      sync.RWMutex
+	records map[string]*Record
+}
+
+// NewStore returns an empty in memory store
+func NewStore() *Store {
+	return &Store{records: make(map[string]*Record)}
+}
+
+// Put saves the record of a credential, replacing any previous registration
+func (st *Store) Put(credentialIdentifier string, record *Record) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.records[credentialIdentifier] = record
+}
+
+// Get returns the record of a credential
+func (st *Store) Get(credentialIdentifier string) (*Record, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	record, ok := st.records[credentialIdentifier]
+	return record, ok
+}
+
+// ServerKeys is the long term key material of an OPAQUE server, shared by every client
+type ServerKeys struct {
+	PrivateKey *big.Int
+	PublicKey  []byte // serialized public key
+	OPRFSeed   []byte // every client's OPRF key is derived from it
+}
+
+// GenerateServerKeys creates the server's AKE key pair and OPRF seed, random may be nil to use crypto/rand
+func GenerateServerKeys(s *suite.Suite, random io.Reader) (*ServerKeys, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+
+	seed, err := randomBytes(random, Nseed)
+	if err != nil {
+		return nil, err
+	}
+
+	sk, pk, err := deriveDiffieHellmanKeyPair(s, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	oprfSeed, err := randomBytes(random, s.NewHash().Size())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServerKeys{PrivateKey: sk, PublicKey: s.EncodePointCompressed(pk), OPRFSeed: oprfSeed}, nil
+}
+
+// FakeRecord returns the record to answer a login for a credential that isn't registered with, so
+// the server doesn't tell who is (RFC 9807 section 10.9). It is derived from the OPRF seed, the same
+// credential always gets the same record and its KE3 never checks out.
+func (keys *ServerKeys) FakeRecord(s *suite.Suite, credentialIdentifier string) (*Record, error) {
+	nh := s.NewHash().Size()
+
+	seed := expand(s, keys.OPRFSeed, append([]byte(credentialIdentifier), "FakePrivateKey"...), Nseed)
+	_, pk, err := deriveDiffieHellmanKeyPair(s, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Record{
+		ClientPublicKey: s.EncodePointCompressed(pk),
+		MaskingKey:      expand(s, keys.OPRFSeed, append([]byte(credentialIdentifier), "FakeMaskingKey"...), nh),
+		Envelope:        make([]byte, Nn+nh),
+	}, nil
+}
+
+// storeEnvelope creates the envelope of a new registration from the randomized password
+func storeEnvelope(s *suite.Suite, randomizedPassword, serverPublicKey, serverIdentity, clientIdentity []byte, random io.Reader) (record *Record, exportKey []byte, err error) {
+	nh := s.NewHash().Size()
+
+	nonce, err := randomBytes(random, Nn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	maskingKey := expand(s, randomizedPassword, []byte("MaskingKey"), nh)
+	authKey := expand(s, randomizedPassword, append(append([]byte{}, nonce...), "AuthKey"...), nh)
+	exportKey = expand(s, randomizedPassword, append(append([]byte{}, nonce...), "ExportKey"...), nh)
+	seed := expand(s, randomizedPassword, append(append([]byte{}, nonce...), "PrivateKey"...), Nseed)
+
+	_, clientPublicKey, err := deriveDiffieHellmanKeyPair(s, seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	pk := s.EncodePointCompressed(clientPublicKey)
+
+	_, _, cleartext := cleartextCredentials(serverPublicKey, pk, serverIdentity, clientIdentity)
+	authTag := mac(s, authKey, append(append([]byte{}, nonce...), cleartext...))
+
+	return &Record{
+		ClientPublicKey: pk,
+		MaskingKey:      maskingKey,
+		Envelope:        append(nonce, authTag...),
+	}, exportKey, nil
+}
+
+// recoverEnvelope opens the envelope and returns the client's private key, the identities and the export key
+func recoverEnvelope(s *suite.Suite, randomizedPassword, serverPublicKey, envelope, serverIdentity, clientIdentity []byte) (clientPrivateKey *big.Int, serverID, clientID, exportKey []byte, err error) {
+	nh := s.NewHash().Size()
+	nonce, authTag := envelope[:Nn], envelope[Nn:]
+
+	authKey := expand(s, randomizedPassword, append(append([]byte{}, nonce...), "AuthKey"...), nh)
+	exportKey = expand(s, randomizedPassword, append(append([]byte{}, nonce...), "ExportKey"...), nh)
+	seed := expand(s, randomizedPassword, append(append([]byte{}, nonce...), "PrivateKey"...), Nseed)
+
+	clientPrivateKey, clientPublicKey, err := deriveDiffieHellmanKeyPair(s, seed)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	serverID, clientID, cleartext := cleartextCredentials(serverPublicKey, s.EncodePointCompressed(clientPublicKey), serverIdentity, clientIdentity)
+	expected := mac(s, authKey, append(append([]byte{}, nonce...), cleartext...))
+	if !hmac.Equal(expected, authTag) {
+		return nil, nil, nil, nil, ErrEnvelopeRecovery
+	}
+
+	return clientPrivateKey, serverID, clientID, exportKey, nil
+}
@@ -0,0 +1,31 @@
+package opaque
+
+import (
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// RegistrationRequest carries the client's blinded password
+type RegistrationRequest struct {
+	Identity string
+	Suite    suite.SuiteOptions
+	Message  []byte
+}
+
+// RegistrationRecordRequest uploads the record at the end of the registration
+type RegistrationRecordRequest struct {
+	Identity string
+	Message  []byte
+}
+
+// LoginRequest carries KE1
+type LoginRequest struct {
+	Identity string
+	Suite    suite.SuiteOptions
+	Message  []byte
+}
+
+// LoginFinishRequest carries KE3
+type LoginFinishRequest struct {
+	SessionID string // issued with KE2
+	Message   []byte
+}
@@ -0,0 +1,22 @@
+package opaque
+
+type RegistrationResponse struct {
+	Identity string
+	Suite    string
+	Context  []byte // bound into every login, the client has to keep it
+	Message  []byte
+}
+
+type RegistrationRecordResponse struct {
+	Identity string
+}
+
+type LoginResponse struct {
+	Identity  string
+	SessionID string // names the session in every later request
+	Message   []byte // KE2
+}
+
+type LoginFinishResponse struct {
+	Identity string
+}
@@ -0,0 +1,191 @@
+package opaque
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/oprf"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// Server runs the server side of a registration or a login with the server's long term keys
+type Server struct {
+	Suite             *suite.Suite
+	Identity          string
+	ClientIdentity    string
+	Context           []byte
+	Keys              *ServerKeys
+	Rand              io.Reader
+	expectedClientMAC []byte
+	sessionKey        []byte
+	state             spake2.State
+}
+
+// NewServer returns a server ready to answer a registration or a login
+func NewServer(identity string, keys *ServerKeys, param *SetUpParams) (*Server, error) {
+	s := suite.SelectECCSuite(param.Suite)
+	if s == nil {
+		return nil, fmt.Errorf("opaque: unknown suite %q", param.Suite)
+	}
+
+	random := param.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	return &Server{
+		Suite:          s,
+		Identity:       identity,
+		ClientIdentity: param.OpponentIdentity,
+		Context:        param.Context,
+		Keys:           keys,
+		Rand:           random,
+		state:          spake2.StateSetUp,
+	}, nil
+}
+
+// State returns the current state of the server
+func (sv *Server) State() spake2.State {
+	return sv.state
+}
+
+// RegistrationResponse evaluates the client's blinded password with the credential's OPRF key
+// and sends it back with the server's public key
+func (sv *Server) RegistrationResponse(request []byte, credentialIdentifier string) ([]byte, error) {
+	blinded, err := oprf.DeserializeElement(sv.Suite, request)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluated, err := sv.evaluate(blinded, credentialIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(oprf.SerializeElement(sv.Suite, evaluated), sv.Keys.PublicKey...), nil
+}
+
+// Respond answers KE1 with KE2: the masked credentials of the record and the server's half of 3DH
+func (sv *Server) Respond(record *Record, credentialIdentifier string, ke1 []byte) ([]byte, error) {
+	if err := sv.expectState("Respond", spake2.StateSetUp); err != nil {
+		return nil, err
+	}
+
+	noe, npk := 1+sv.Suite.ByteLen(), 1+sv.Suite.ByteLen()
+	if len(ke1) != noe+Nn+npk {
+		return nil, ErrUnexpectedMessage
+	}
+
+	blinded, err := oprf.DeserializeElement(sv.Suite, ke1[:noe])
+	if err != nil {
+		return nil, err
+	}
+	clientKeyshare, err := sv.Suite.DecodePoint(ke1[noe+Nn:])
+	if err != nil {
+		return nil, ErrUnexpectedMessage
+	}
+	clientPublicKey, err := sv.Suite.DecodePoint(record.ClientPublicKey)
+	if err != nil {
+		return nil, ErrUnexpectedMessage
+	}
+
+	evaluated, err := sv.evaluate(blinded, credentialIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	// mask the server's public key and the envelope so only the client can read them
+	maskingNonce, err := randomBytes(sv.Rand, Nn)
+	if err != nil {
+		return nil, err
+	}
+	plain := append(append([]byte{}, sv.Keys.PublicKey...), record.Envelope...)
+	pad := expand(sv.Suite, record.MaskingKey, append(append([]byte{}, maskingNonce...), "CredentialResponsePad"...), len(plain))
+
+	credentialResponse := oprf.SerializeElement(sv.Suite, evaluated)
+	credentialResponse = append(credentialResponse, maskingNonce...)
+	credentialResponse = append(credentialResponse, xor(pad, plain)...)
+
+	serverNonce, err := randomBytes(sv.Rand, Nn)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := randomBytes(sv.Rand, Nseed)
+	if err != nil {
+		return nil, err
+	}
+	serverSecret, serverKeyshare, err := deriveDiffieHellmanKeyPair(sv.Suite, seed)
+	if err != nil {
+		return nil, err
+	}
+	serverKeyshareBytes := sv.Suite.EncodePointCompressed(serverKeyshare)
+
+	// 3DH from the server's side
+	ikm := sv.Suite.EncodePointCompressed(sv.Suite.Multiply(clientKeyshare, serverSecret))
+	ikm = append(ikm, sv.Suite.EncodePointCompressed(sv.Suite.Multiply(clientKeyshare, sv.Keys.PrivateKey))...)
+	ikm = append(ikm, sv.Suite.EncodePointCompressed(sv.Suite.Multiply(clientPublicKey, serverSecret))...)
+
+	serverID, clientID, _ := cleartextCredentials(sv.Keys.PublicKey, record.ClientPublicKey, []byte(sv.Identity), []byte(sv.ClientIdentity))
+	pre := preamble(sv.Context, clientID, ke1, serverID, credentialResponse, serverNonce, serverKeyshareBytes)
+	km2, km3, sessionKey := deriveKeys(sv.Suite, ikm, pre)
+
+	serverMAC := mac(sv.Suite, km2, hash(sv.Suite, pre))
+	sv.expectedClientMAC = mac(sv.Suite, km3, hash(sv.Suite, append(pre, serverMAC...)))
+	sv.sessionKey = sessionKey
+
+	ke2 := append(credentialResponse, serverNonce...)
+	ke2 = append(ke2, serverKeyshareBytes...)
+	ke2 = append(ke2, serverMAC...)
+
+	sv.state = spake2.StateKeyDerived
+	return ke2, nil
+}
+
+// Finish checks the client's MAC in KE3 and returns the session
+func (sv *Server) Finish(ke3 []byte) (*spake2.Session, error) {
+	if err := sv.expectState("Finish", spake2.StateKeyDerived); err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(sv.expectedClientMAC, ke3) {
+		sv.Close()
+		return nil, ErrClientAuthentication
+	}
+
+	sv.state = spake2.StateConfirmed
+	return spake2.NewSession(sv.Suite, suite.Server, sv.Identity, sv.ClientIdentity, sv.sessionKey)
+}
+
+// Close overwrites the expected client MAC and the session key, the server's long term keys are left alone
+func (sv *Server) Close() {
+	suite.Wipe(sv.expectedClientMAC)
+	suite.Wipe(sv.sessionKey)
+
+	sv.expectedClientMAC, sv.sessionKey = nil, nil
+	sv.state = spake2.StateClosed
+}
+
+// evaluate derives the credential's OPRF key from the server's seed and evaluates the blinded element
+func (sv *Server) evaluate(blinded *suite.Point, credentialIdentifier string) (*suite.Point, error) {
+	nok := (sv.Suite.Curve.Params().N.BitLen() + 7) / 8
+	seed := expand(sv.Suite, sv.Keys.OPRFSeed, append([]byte(credentialIdentifier), "OprfKey"...), nok)
+
+	oprfKey, _, err := oprf.DeriveKeyPair(sv.Suite, seed, []byte("OPAQUE-DeriveKeyPair"))
+	if err != nil {
+		return nil, err
+	}
+
+	return oprf.BlindEvaluate(sv.Suite, oprfKey, blinded)
+}
+
+// expectState returns a StateError unless the server is in the given state
+func (sv *Server) expectState(op string, state spake2.State) error {
+	if sv.state != state {
+		return &spake2.StateError{Op: op, State: sv.state, Expected: []spake2.State{state}}
+	}
+
+	return nil
+}
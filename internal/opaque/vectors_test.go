@@ -0,0 +1,208 @@
+package opaque
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"math/big"
+	"testing"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// vector holds the inputs and outputs of one RFC 9807 Appendix C test vector, everything hex encoded.
+// The randomness is fed to the client and server in the order they read it
+type vector struct {
+	Suite                suite.SuiteOptions
+	OPRFSeed             string
+	CredentialIdentifier string
+	Password             string
+	Context              string
+	ServerPrivateKey     string
+	ServerPublicKey      string
+	BlindRegistration    string
+	EnvelopeNonce        string
+	BlindLogin           string
+	ClientNonce          string
+	ClientKeyshareSeed   string
+	MaskingNonce         string
+	ServerNonce          string
+	ServerKeyshareSeed   string
+
+	RegistrationRequest  string
+	RegistrationResponse string
+	RegistrationUpload   string
+	KE1                  string
+	KE2                  string // up to the server's MAC, which is checked against ServerMACKey
+	ServerMACKey         string
+	ClientMACKey         string
+}
+
+// vectors from RFC 9807 Appendix C.1.5, OPAQUE-3DH with P-256, SHA-256 and the identity KSF,
+// without client or server identities
+var vectors = []vector{
+	{
+		Suite:                suite.P256,
+		OPRFSeed:             "62f60b286d20ce4fd1d64809b0021dad6ed5d52a2c8cf27ae6582543a0a8dce2",
+		CredentialIdentifier: "31323334",
+		Password:             "436f7272656374486f72736542617474657279537461706c65",
+		Context:              "4f50415155452d504f43",
+		ServerPrivateKey:     "c36139381df63bfc91c850db0b9cfbec7a62e86d80040a41aa7725bf0e79d5e5",
+		ServerPublicKey:      "035f40ff9cf88aa1f5cd4fe5fd3da9ea65a4923a5594f84fd9f2092d6067784874",
+		BlindRegistration:    "411bf1a62d119afe30df682b91a0a33d777972d4f2daa4b34ca527d597078153",
+		EnvelopeNonce:        "a921f2a014513bd8a90e477a629794e89fec12d12206dde662ebdcf65670e51f",
+		BlindLogin:           "c497fddf6056d241e6cf9fb7ac37c384f49b357a221eb0a802c989b9942256c1",
+		ClientNonce:          "ab3d33bde0e93eda72392346a7a73051110674bbf6b1b7ffab8be4f91fdaeeb1",
+		ClientKeyshareSeed:   "633b875d74d1556d2a2789309972b06db21dfcc4f5ad51d7e74d783b7cfab8dc",
+		MaskingNonce:         "38fe59af0df2c79f57b8780278f5ae47355fe1f817119041951c80f612fdfc6d",
+		ServerNonce:          "71cd9960ecef2fe0d0f7494986fa3d8b2bb01963537e60efb13981e138e3d4a1",
+		ServerKeyshareSeed:   "05a4f54206eef1ba2f615bc0aa285cb22f26d1153b5b40a1e85ff80da12f982f",
+
+		RegistrationRequest:  "029e949a29cfa0bf7c1287333d2fb3dc586c41aa652f5070d26a5315a1b50229f8",
+		RegistrationResponse: "0350d3694c00978f00a5ce7cd08a00547e4ab5fb5fc2b2f6717cdaa6c89136efef035f40ff9cf88aa1f5cd4fe5fd3da9ea65a4923a5594f84fd9f2092d6067784874",
+		RegistrationUpload:   "03b218507d978c3db570ca994aaf36695a731ddb2db272c817f79746fc37ae52147f0ed53532d3ae8e505ecc70d42d2b814b6b0e48156def71ea029148b2803aafa921f2a014513bd8a90e477a629794e89fec12d12206dde662ebdcf65670e51fad30bbcfc1f8eda0211553ab9aaf26345ad59a128e80188f035fe4924fad67b8",
+		KE1:                  "037342f0bcb3ecea754c1e67576c86aa90c1de3875f390ad599a26686cdfee6e07ab3d33bde0e93eda72392346a7a73051110674bbf6b1b7ffab8be4f91fdaeeb1022ed3f32f318f81bab80da321fecab3cd9b6eea11a95666dfa6beeaab321280b6",
+		KE2:                  "0246da9fe4d41d5ba69faa6c509a1d5bafd49a48615a47a8dd4b0823cc1476481138fe59af0df2c79f57b8780278f5ae47355fe1f817119041951c80f612fdfc6d2f0c547f70deaeca54d878c14c1aa5e1ab405dec833777132eea905c2fbb12504a67dcbe0e66740c76b62c13b04a38a77926e19072953319ec65e41f9bfd2ae26837b6ce688bf9af2542f04eec9ab96a1b9328812dc2f5c89182ed47fead61f09f71cd9960ecef2fe0d0f7494986fa3d8b2bb01963537e60efb13981e138e3d4a103c1701353219b53acf337bf6456a83cefed8f563f1040b65afbf3b65d3bc9a19b",
+		ServerMACKey:         "13e928581febfad28855e3e7f03306d61bd69489686f621535d44a1365b73b0d",
+		ClientMACKey:         "afdc53910c25183b08b930e6953c35b3466276736d9de2e9c5efaf150f4082c5",
+	},
+}
+
+// TestVectors registers and logs in with the randomness of each vector, checks every message against
+// it and checks the MACs of KE2 and KE3 with the vector's MAC keys
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		t.Run(v.CredentialIdentifier, func(t *testing.T) {
+			expect := func(name string, got []byte, want string) {
+				t.Helper()
+				if !bytes.Equal(got, paketest.MustHex(want)) {
+					t.Fatalf("%s mismatch: got %x, want %s", name, got, want)
+				}
+			}
+			randomness := func(values ...string) *bytes.Reader {
+				var b []byte
+				for _, v := range values {
+					b = append(b, paketest.MustHex(v)...)
+				}
+				return bytes.NewReader(b)
+			}
+
+			s := suite.SelectECCSuite(v.Suite)
+			keys := &ServerKeys{
+				PrivateKey: new(big.Int).SetBytes(paketest.MustHex(v.ServerPrivateKey)),
+				PublicKey:  paketest.MustHex(v.ServerPublicKey),
+				OPRFSeed:   paketest.MustHex(v.OPRFSeed),
+			}
+			credentialIdentifier := string(paketest.MustHex(v.CredentialIdentifier))
+			context := paketest.MustHex(v.Context)
+			pw := string(paketest.MustHex(v.Password))
+
+			// registration
+			client, err := NewClient("", &SetUpParams{Suite: v.Suite, Pw: pw, Context: context, KSF: IdentityKSF,
+				Rand: randomness(v.BlindRegistration, v.EnvelopeNonce)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := NewServer("", keys, &SetUpParams{Suite: v.Suite, Context: context})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request, err := client.RegistrationRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect("registration_request", request, v.RegistrationRequest)
+
+			response, err := server.RegistrationResponse(request, credentialIdentifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect("registration_response", response, v.RegistrationResponse)
+
+			upload, err := client.FinalizeRegistration(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect("registration_upload", upload, v.RegistrationUpload)
+			registrationExportKey := client.ExportKey()
+
+			record, err := DecodeRecord(s, upload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// login
+			client, err = NewClient("", &SetUpParams{Suite: v.Suite, Pw: pw, Context: context, KSF: IdentityKSF,
+				Rand: randomness(v.BlindLogin, v.ClientNonce, v.ClientKeyshareSeed)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err = NewServer("", keys, &SetUpParams{Suite: v.Suite, Context: context,
+				Rand: randomness(v.MaskingNonce, v.ServerNonce, v.ServerKeyshareSeed)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ke1, err := client.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect("KE1", ke1, v.KE1)
+
+			ke2, err := server.Respond(record, credentialIdentifier, ke1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			macStart := len(ke2) - s.NewHash().Size()
+			expect("KE2", ke2[:macStart], v.KE2)
+
+			// rebuild the key schedule from the server's secrets to check the MAC keys
+			npk := 1 + s.ByteLen()
+			credentialResponse := ke2[:macStart-Nn-npk]
+			serverSecret, _, err := deriveDiffieHellmanKeyPair(s, paketest.MustHex(v.ServerKeyshareSeed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientKeyshare, err := s.DecodePoint(ke1[len(ke1)-npk:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientPublicKey, err := s.DecodePoint(record.ClientPublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ikm := s.EncodePointCompressed(s.Multiply(clientKeyshare, serverSecret))
+			ikm = append(ikm, s.EncodePointCompressed(s.Multiply(clientKeyshare, keys.PrivateKey))...)
+			ikm = append(ikm, s.EncodePointCompressed(s.Multiply(clientPublicKey, serverSecret))...)
+			pre := preamble(context, record.ClientPublicKey, ke1, keys.PublicKey, credentialResponse,
+				ke2[macStart-Nn-npk:macStart-npk], ke2[macStart-npk:macStart])
+
+			km2, km3, _ := deriveKeys(s, ikm, pre)
+			expect("server_mac_key", km2, v.ServerMACKey)
+			expect("client_mac_key", km3, v.ClientMACKey)
+			if !hmac.Equal(ke2[macStart:], mac(s, km2, hash(s, pre))) {
+				t.Fatalf("server_mac doesn't match the vector's server_mac_key: %x", ke2[macStart:])
+			}
+
+			ke3, clientSession, err := client.Finish(ke2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !hmac.Equal(ke3, mac(s, km3, hash(s, append(pre, ke2[macStart:]...)))) {
+				t.Fatalf("KE3 doesn't match the vector's client_mac_key: %x", ke3)
+			}
+			serverSession, err := server.Finish(ke3)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !paketest.SessionsAgree(clientSession, serverSession) {
+				t.Error("client and server disagree on the session key")
+			}
+			if !bytes.Equal(client.ExportKey(), registrationExportKey) {
+				t.Error("login and registration disagree on the export key")
+			}
+		})
+	}
+}
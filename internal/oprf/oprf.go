@@ -0,0 +1,153 @@
+// Package oprf implements the base mode OPRF of RFC 9497 over the suite package's curves.
+// The client blinds its input, the server evaluates the blinded element with its key and the client
+// unblinds and hashes the result, so the server never sees the input and the client never learns the key.
+package oprf
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// ModeOPRF is the base mode, the only one implemented here
+const ModeOPRF byte = 0
+
+var (
+	// ErrInvalidInput is returned when an input hashes to the identity
+	ErrInvalidInput = errors.New("oprf: invalid input")
+
+	// ErrInvalidElement is returned when an element received from the peer is the identity or not on the curve
+	ErrInvalidElement = errors.New("oprf: invalid element")
+
+	// ErrDeriveKeyPair is returned when no key could be derived from the seed
+	ErrDeriveKeyPair = errors.New("oprf: key pair derivation failed")
+)
+
+// identifiers of the RFC 9497 ciphersuites per suite
+var identifiers = map[suite.SuiteOptions]string{
+	suite.P256:       "P256-SHA256",
+	suite.P256CMAC:   "P256-SHA256", // neither the MAC nor the AEAD take part in the OPRF
+	suite.P256ChaCha: "P256-SHA256",
+}
+
+// ContextString returns "OPRFV1-" || I2OSP(mode, 1) || "-" || identifier
+func ContextString(s *suite.Suite) ([]byte, error) {
+	id, ok := identifiers[s.Name]
+	if !ok {
+		return nil, fmt.Errorf("oprf: unsupported suite %q", s.Name)
+	}
+
+	return append([]byte{'O', 'P', 'R', 'F', 'V', '1', '-', ModeOPRF, '-'}, id...), nil
+}
+
+// Blind hashes input to the group and blinds it with a random scalar, random may be nil to use crypto/rand
+func Blind(s *suite.Suite, input []byte, random io.Reader) (blind *big.Int, blindedElement *suite.Point, err error) {
+	if random == nil {
+		random = rand.Reader
+	}
+
+	// RandomScalar, in [1, n-1]
+	for blind == nil || blind.Sign() == 0 {
+		blind, err = rand.Int(random, s.Curve.Params().N)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	inputElement, err := hashToGroup(s, input)
+	if err != nil {
+		return nil, nil, err
+	}
+	if inputElement.IsIdentity() {
+		return nil, nil, ErrInvalidInput
+	}
+
+	return blind, s.Multiply(inputElement, blind), nil
+}
+
+// BlindEvaluate is the server side, it multiplies the client's blinded element by the key
+func BlindEvaluate(s *suite.Suite, sk *big.Int, blindedElement *suite.Point) (*suite.Point, error) {
+	if blindedElement == nil || blindedElement.IsIdentity() || !s.IsOnCurve(blindedElement) {
+		return nil, ErrInvalidElement
+	}
+
+	return s.Multiply(blindedElement, sk), nil
+}
+
+// Finalize unblinds the server's evaluation and hashes it with the input into the OPRF output
+func Finalize(s *suite.Suite, input []byte, blind *big.Int, evaluatedElement *suite.Point) ([]byte, error) {
+	if evaluatedElement == nil || evaluatedElement.IsIdentity() || !s.IsOnCurve(evaluatedElement) {
+		return nil, ErrInvalidElement
+	}
+
+	inverse := new(big.Int).ModInverse(blind, s.Curve.Params().N)
+	unblinded := SerializeElement(s, s.Multiply(evaluatedElement, inverse))
+
+	// hashInput = I2OSP(len(input), 2) || input || I2OSP(len(unblindedElement), 2) || unblindedElement || "Finalize"
+	h := s.NewHash()
+	h.Write(lengthPrefixed(input))
+	h.Write(lengthPrefixed(unblinded))
+	h.Write([]byte("Finalize"))
+
+	return h.Sum(nil), nil
+}
+
+// DeriveKeyPair deterministically derives a key pair from seed and info (RFC 9497 section 3.2.1)
+func DeriveKeyPair(s *suite.Suite, seed, info []byte) (sk *big.Int, pk *suite.Point, err error) {
+	contextString, err := ContextString(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deriveInput := append(append([]byte{}, seed...), lengthPrefixed(info)...)
+	dst := append([]byte("DeriveKeyPair"), contextString...)
+
+	for counter := 0; counter <= 255; counter++ {
+		sk, err = s.HashToScalar(append(deriveInput, byte(counter)), dst)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if sk.Sign() != 0 {
+			return sk, s.BaseMultiply(sk), nil
+		}
+	}
+
+	return nil, nil, ErrDeriveKeyPair
+}
+
+// SerializeElement returns the compressed encoding RFC 9497 puts on the wire
+func SerializeElement(s *suite.Suite, p *suite.Point) []byte {
+	return s.EncodePointCompressed(p)
+}
+
+// DeserializeElement parses an element, the identity has no valid encoding
+func DeserializeElement(s *suite.Suite, b []byte) (*suite.Point, error) {
+	p, err := s.DecodePoint(b)
+	if err != nil {
+		return nil, ErrInvalidElement
+	}
+
+	return p, nil
+}
+
+// hashToGroup hashes the input with the DST "HashToGroup-" || contextString
+func hashToGroup(s *suite.Suite, input []byte) (*suite.Point, error) {
+	contextString, err := ContextString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.HashToCurve(input, append([]byte("HashToGroup-"), contextString...))
+}
+
+// lengthPrefixed returns I2OSP(len(b), 2) || b
+func lengthPrefixed(b []byte) []byte {
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(b)))
+	return append(out, b...)
+}
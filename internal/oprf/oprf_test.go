@@ -0,0 +1,89 @@
+package oprf_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/oprf"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/paketest"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// vector holds one RFC 9497 Appendix A test vector, everything hex encoded
+type vector struct {
+	Suite            suite.SuiteOptions
+	Seed             string
+	KeyInfo          string
+	SkSm             string
+	Input            string
+	Blind            string
+	BlindedElement   string
+	EvaluatedElement string
+	Output           string
+}
+
+// vectors from RFC 9497 Appendix A.3.1, OPRF(P-256, SHA-256) in base mode
+var vectors = []vector{
+	{
+		Suite:            suite.P256,
+		Seed:             "a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3",
+		KeyInfo:          "74657374206b6579",
+		SkSm:             "159749d750713afe245d2d39ccfaae8381c53ce92d098a9375ee70739c7ac0bf",
+		Input:            "00",
+		Blind:            "3338fa65ec36e0290022b48eb562889d89dbfa691d1cde91517fa222ed7ad364",
+		BlindedElement:   "03723a1e5c09b8b9c18d1dcbca29e8007e95f14f4732d9346d490ffc195110368d",
+		EvaluatedElement: "030de02ffec47a1fd53efcdd1c6faf5bdc270912b8749e783c7ca75bb412958832",
+		Output:           "a0b34de5fa4c5b6da07e72af73cc507cceeb48981b97b7285fc375345fe495dd",
+	},
+	{
+		Suite:            suite.P256,
+		Seed:             "a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3",
+		KeyInfo:          "74657374206b6579",
+		SkSm:             "159749d750713afe245d2d39ccfaae8381c53ce92d098a9375ee70739c7ac0bf",
+		Input:            "5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a",
+		Blind:            "3338fa65ec36e0290022b48eb562889d89dbfa691d1cde91517fa222ed7ad364",
+		BlindedElement:   "03cc1df781f1c2240a64d1c297b3f3d16262ef5d4cf102734882675c26231b0838",
+		EvaluatedElement: "03a0395fe3828f2476ffcd1f4fe540e5a8489322d398be3c4e5a869db7fcb7c52c",
+		Output:           "c748ca6dd327f0ce85f4ae3a8cd6d4d5390bbb804c9e12dcf94f853fece3dcce",
+	},
+}
+
+// TestVectors derives the key of each vector and runs blind, evaluate and finalize with its blind
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		t.Run(v.Input, func(t *testing.T) {
+			expect := func(name string, got []byte, want string) {
+				t.Helper()
+				if !bytes.Equal(got, paketest.MustHex(want)) {
+					t.Errorf("%s mismatch: got %x, want %s", name, got, want)
+				}
+			}
+
+			s := suite.SelectECCSuite(v.Suite)
+
+			sk, _, err := oprf.DeriveKeyPair(s, paketest.MustHex(v.Seed), paketest.MustHex(v.KeyInfo))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect("skSm", s.EncodeScalar(sk), v.SkSm)
+
+			blind, blinded, err := oprf.Blind(s, paketest.MustHex(v.Input), bytes.NewReader(paketest.MustHex(v.Blind)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect("BlindedElement", oprf.SerializeElement(s, blinded), v.BlindedElement)
+
+			evaluated, err := oprf.BlindEvaluate(s, sk, blinded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect("EvaluationElement", oprf.SerializeElement(s, evaluated), v.EvaluatedElement)
+
+			output, err := oprf.Finalize(s, paketest.MustHex(v.Input), blind, evaluated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect("Output", output, v.Output)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package paketest has what the tests of the PAKE packages share: playing both sides of a
+// handshake against each other and telling whether they ended up with the same keys.
+// Only tests import it.
+package paketest
+
+import (
+	"encoding/hex"
+
+	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
+)
+
+// RunHandshake plays both sides of a handshake against each other
+func RunHandshake(a, b spake2.Handshake) (sessionA, sessionB *spake2.Session, err error) {
+	shareA, err := a.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+	shareB, err := b.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	confirmA, err := a.Respond(shareB)
+	if err != nil {
+		return nil, nil, err
+	}
+	confirmB, err := b.Respond(shareA)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if sessionA, err = a.Finish(confirmB); err != nil {
+		return nil, nil, err
+	}
+	if sessionB, err = b.Finish(confirmA); err != nil {
+		return nil, nil, err
+	}
+
+	return sessionA, sessionB, nil
+}
+
+// SessionsAgree tells whether a record sealed by a opens with b, which only happens when
+// both derived their keys from the same handshake key
+func SessionsAgree(a, b *spake2.Session) bool {
+	record, err := a.Seal([]byte("paketest"), nil)
+	if err != nil {
+		return false
+	}
+	plain, err := b.Open(record, nil)
+
+	return err == nil && string(plain) == "paketest"
+}
+
+// MustHex decodes the hex constants of test vectors
+func MustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
@@ -0,0 +1,118 @@
+// Package schnorr implements the Schnorr non-interactive zero-knowledge proof of RFC 8235 over the
+// suite package's curves: a proof that the prover knows a for A = a*G without revealing a.
+// G doesn't have to be the curve's base point, J-PAKE proves knowledge against other generators.
+package schnorr
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// ErrInvalidProof is returned when a proof can't be decoded
+var ErrInvalidProof = errors.New("schnorr: invalid proof encoding")
+
+// Proof is the (V, r) pair of RFC 8235 section 3.2
+type Proof struct {
+	V *suite.Point // v*G for the random v
+	R *big.Int     // v - a*c mod n
+}
+
+// Prove proves knowledge of a where A = a*G, random may be nil to use crypto/rand
+func Prove(s *suite.Suite, g *suite.Point, a *big.Int, A *suite.Point, userID string, otherInfo []byte, random io.Reader) (*Proof, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+
+	n := s.Curve.Params().N
+
+	// v in [1, n-1]
+	v, err := rand.Int(random, new(big.Int).Sub(n, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	v.Add(v, big.NewInt(1))
+
+	V := s.Multiply(g, v)
+	c := challenge(s, g, V, A, userID, otherInfo)
+
+	r := new(big.Int).Mul(a, c)
+	r.Sub(v, r)
+	r.Mod(r, n)
+
+	return &Proof{V: V, R: r}, nil
+}
+
+// Verify checks the proof for A = a*G was made by userID, following RFC 8235 section 3.3
+func Verify(s *suite.Suite, g *suite.Point, A *suite.Point, proof *Proof, userID string, otherInfo []byte) bool {
+	// A has to be a valid point other than the identity, the cofactor of our curves is 1
+	if A == nil || A.IsIdentity() || !s.IsOnCurve(A) {
+		return false
+	}
+	if proof == nil || proof.V == nil || proof.V.IsIdentity() || !s.IsOnCurve(proof.V) {
+		return false
+	}
+	if proof.R == nil || proof.R.Sign() < 0 || proof.R.Cmp(s.Curve.Params().N) >= 0 {
+		return false
+	}
+
+	// V = r*G + c*A
+	c := challenge(s, g, proof.V, A, userID, otherInfo)
+	expected := s.Add(multiply(s, g, proof.R), multiply(s, A, c))
+	if expected.IsIdentity() {
+		return false
+	}
+
+	return expected.X.Cmp(proof.V.X) == 0 && expected.Y.Cmp(proof.V.Y) == 0
+}
+
+// Bytes encodes the proof as V || r
+func (p *Proof) Bytes(s *suite.Suite) []byte {
+	return append(s.EncodePoint(p.V), s.EncodeScalar(p.R)...)
+}
+
+// Size returns the length of an encoded proof for the suite
+func Size(s *suite.Suite) int {
+	return 1 + 2*s.ByteLen() + (s.Curve.Params().N.BitLen()+7)/8
+}
+
+// DecodeProof parses a proof encoded by Bytes
+func DecodeProof(s *suite.Suite, b []byte) (*Proof, error) {
+	if len(b) != Size(s) {
+		return nil, ErrInvalidProof
+	}
+
+	pointLen := 1 + 2*s.ByteLen()
+	v, err := s.DecodePoint(b[:pointLen])
+	if err != nil {
+		return nil, ErrInvalidProof
+	}
+
+	return &Proof{V: v, R: new(big.Int).SetBytes(b[pointLen:])}, nil
+}
+
+// challenge computes c = H(G || V || A || UserID || OtherInfo) where each item carries a 4 byte length
+func challenge(s *suite.Suite, g, V, A *suite.Point, userID string, otherInfo []byte) *big.Int {
+	h := s.NewHash()
+	for _, item := range [][]byte{s.EncodePoint(g), s.EncodePoint(V), s.EncodePoint(A), []byte(userID), otherInfo} {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(item)))
+		h.Write(length[:])
+		h.Write(item)
+	}
+
+	return new(big.Int).Mod(new(big.Int).SetBytes(h.Sum(nil)), s.Curve.Params().N)
+}
+
+// multiply is Multiply that also accepts a zero scalar
+func multiply(s *suite.Suite, p *suite.Point, n *big.Int) *suite.Point {
+	if n.Sign() == 0 {
+		return &suite.Point{}
+	}
+
+	return s.Multiply(p, n)
+}
@@ -0,0 +1,37 @@
+package suite
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+type AEADOptions string
+
+const (
+	AES128GCM        AEADOptions = "AES-128-GCM"
+	ChaCha20Poly1305 AEADOptions = "ChaCha20-Poly1305"
+)
+
+// NewAES128GCM returns AES-GCM, the key has to be 16 bytes
+func NewAES128GCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// SelectAEAD returns the constructor and key size for the given AEAD algorithm
+func SelectAEAD(name AEADOptions) (newAEAD func(key []byte) (cipher.AEAD, error), keySize int) {
+	switch name {
+	case AES128GCM:
+		return NewAES128GCM, 16
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New, chacha20poly1305.KeySize
+	default:
+		return nil, 0
+	}
+}
@@ -0,0 +1,95 @@
+package suite
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+)
+
+// cmacRb is the constant used when generating CMAC subkeys for 128 bit blocks (RFC 4493 section 2.3)
+const cmacRb = 0x87
+
+// ErrCMACKeySize is returned for a CMAC-AES-128 key that isn't 16 bytes
+var ErrCMACKeySize = errors.New("suite: CMAC-AES-128 requires a 16 byte key")
+
+// CMACAES128Tag computes AES-CMAC (RFC 4493) of msg under a 16 byte key
+func CMACAES128Tag(key, msg []byte) ([]byte, error) {
+	if len(key) != 16 {
+		return nil, ErrCMACKeySize
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	k1, k2 := cmacSubkeys(block)
+
+	// number of blocks, an empty message still counts as one (padded) block
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	complete := n > 0 && len(msg)%aes.BlockSize == 0
+	if n == 0 {
+		n = 1
+	}
+
+	// prepare the last block, xor-ed with K1 if complete and padded + xor-ed with K2 otherwise
+	last := make([]byte, aes.BlockSize)
+	if complete {
+		copy(last, msg[(n-1)*aes.BlockSize:])
+		xorBlock(last, k1)
+	} else {
+		rest := msg[(n-1)*aes.BlockSize:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xorBlock(last, k2)
+	}
+
+	// CBC-MAC over everything but the last block
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xorBlock(x, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(x, x)
+	}
+
+	xorBlock(x, last)
+	block.Encrypt(x, x)
+
+	return x, nil
+}
+
+// cmacSubkeys generates K1 and K2 from the cipher
+func cmacSubkeys(block cipher.Block) (k1, k2 []byte) {
+	l := make([]byte, aes.BlockSize)
+	block.Encrypt(l, l)
+
+	k1 = shiftLeft(l)
+	if l[0]&0x80 != 0 {
+		k1[aes.BlockSize-1] ^= cmacRb
+	}
+
+	k2 = shiftLeft(k1)
+	if k1[0]&0x80 != 0 {
+		k2[aes.BlockSize-1] ^= cmacRb
+	}
+
+	return k1, k2
+}
+
+// shiftLeft returns the block shifted one bit to the left
+func shiftLeft(b []byte) []byte {
+	out := make([]byte, len(b))
+	var carry byte
+	for i := len(b) - 1; i >= 0; i-- {
+		out[i] = b[i]<<1 | carry
+		carry = b[i] >> 7
+	}
+
+	return out
+}
+
+// xorBlock xors src into dst
+func xorBlock(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,67 @@
+package suite_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// cmacKey is the AES-128 key of every RFC 4493 section 4 example
+const cmacKey = "2b7e151628aed2a6abf7158809cf4f3c"
+
+// cmacVectors from RFC 4493 section 4, the message is a prefix of the same 64 bytes each time
+var cmacVectors = []struct {
+	Msg string
+	Tag string
+}{
+	{
+		Msg: "",
+		Tag: "bb1d6929e95937287fa37d129b756746",
+	},
+	{
+		Msg: "6bc1bee22e409f96e93d7e117393172a",
+		Tag: "070a16b46b4d4144f79bdd9dd04a287c",
+	},
+	{
+		Msg: "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411",
+		Tag: "dfa66747de9ae63030ca32611497c827",
+	},
+	{
+		Msg: "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710",
+		Tag: "51f0bebf7e3b9d92fc49741779363cfe",
+	},
+}
+
+// TestCMAC computes the tag of each RFC 4493 example, covering the empty, complete and padded last block
+func TestCMAC(t *testing.T) {
+	key, _ := hex.DecodeString(cmacKey)
+	for _, v := range cmacVectors {
+		msg, _ := hex.DecodeString(v.Msg)
+
+		tag, err := suite.CMACAES128Tag(key, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(tag); got != v.Tag {
+			t.Errorf("tag of a %d byte message: got %s, want %s", len(msg), got, v.Tag)
+		}
+	}
+}
+
+// TestCMACKeySize checks a key that isn't 16 bytes is refused instead of used, and that the suite
+// then verifies nothing
+func TestCMACKeySize(t *testing.T) {
+	for _, size := range []int{0, 15, 17, 32} {
+		if _, err := suite.CMACAES128Tag(make([]byte, size), []byte("msg")); !errors.Is(err, suite.ErrCMACKeySize) {
+			t.Errorf("%d byte key: got %v, want %v", size, err, suite.ErrCMACKeySize)
+		}
+	}
+
+	s := suite.SelectECCSuite(suite.P256CMAC)
+	if s.VerifyTag(make([]byte, 32), []byte("msg"), bytes.Repeat([]byte{0}, 16)) {
+		t.Error("a tag verified under a key CMAC can't take")
+	}
+}
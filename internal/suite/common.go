@@ -5,18 +5,29 @@ type SuiteOptions string
 type Role string
 
 const (
-	P256 SuiteOptions = "P256"
+	P256       SuiteOptions = "P256"
+	P256CMAC   SuiteOptions = "P256-CMAC"             // P256 with CMAC-AES-128 key confirmation, for peers that only have an AES engine
+	P256ChaCha SuiteOptions = "P256-ChaCha20Poly1305" // P256 with ChaCha20-Poly1305 sessions, for peers without AES hardware
 )
 
 const (
-	Server Role = "Server"
-	Client Role = "Client"
+	Server    Role = "Server"
+	Client    Role = "Client"
+	Symmetric Role = "Symmetric" // peer to peer, neither side knows whether it is A or B
 )
 
 func SelectECCSuite(name SuiteOptions) *Suite {
 	switch name {
 	case P256:
 		return NewP256Suite()
+	case P256CMAC:
+		s := NewP256Suite().SetMAC(CMACAES128)
+		s.Name = P256CMAC
+		return s
+	case P256ChaCha:
+		s := NewP256Suite().SetAEAD(ChaCha20Poly1305)
+		s.Name = P256ChaCha
+		return s
 	default:
 		return nil
 	}
@@ -0,0 +1,149 @@
+package suite
+
+import (
+	"errors"
+	"math/big"
+)
+
+// HashToCurve maps msg to a point following RFC 9380 hash_to_curve with expand_message_xmd and
+// the simplified SWU map (the _XMD:SHA-256_SSWU_RO_ suites for P256). Nobody knows the discrete log
+// of the result, unlike hashing to a scalar and multiplying the generator.
+func (s *Suite) HashToCurve(msg, dst []byte) (*Point, error) {
+	u, err := s.hashToField(msg, dst, 2, s.Curve.Params().P)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cofactor of the curves we support is 1, no clearing needed
+	return s.Add(s.MapToCurveSSWU(u[0]), s.MapToCurveSSWU(u[1])), nil
+}
+
+// EncodeToCurve is the nonuniform RFC 9380 encode_to_curve (the _SSWU_NU_ suites), cheaper than HashToCurve
+func (s *Suite) EncodeToCurve(msg, dst []byte) (*Point, error) {
+	u, err := s.hashToField(msg, dst, 1, s.Curve.Params().P)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.MapToCurveSSWU(u[0]), nil
+}
+
+// HashToScalar hashes msg to a scalar modulo the group order, hash_to_field with the order as modulus
+func (s *Suite) HashToScalar(msg, dst []byte) (*big.Int, error) {
+	u, err := s.hashToField(msg, dst, 1, s.Curve.Params().N)
+	if err != nil {
+		return nil, err
+	}
+
+	return u[0], nil
+}
+
+// hashToField hashes msg to count elements modulo p (RFC 9380 section 5.2), p is the field
+// characteristic when hashing to the curve
+func (s *Suite) hashToField(msg, dst []byte, count int, p *big.Int) ([]*big.Int, error) {
+	// L = ceil((ceil(log2(p)) + k) / 8)
+	l := (p.BitLen() + s.K + 7) / 8
+
+	uniform, err := s.ExpandMessageXMD(msg, dst, count*l)
+	if err != nil {
+		return nil, err
+	}
+
+	u := make([]*big.Int, count)
+	for i := range u {
+		u[i] = new(big.Int).SetBytes(uniform[i*l : (i+1)*l])
+		u[i].Mod(u[i], p)
+	}
+
+	return u, nil
+}
+
+// ExpandMessageXMD implements expand_message_xmd of RFC 9380 section 5.3.1 with the suite's hash
+func (s *Suite) ExpandMessageXMD(msg, dst []byte, length int) ([]byte, error) {
+	h := s.NewHash()
+	bInBytes, sInBytes := h.Size(), h.BlockSize()
+
+	ell := (length + bInBytes - 1) / bInBytes
+	if ell > 255 || length > 65535 {
+		return nil, errors.New("suite: expand_message_xmd output too long")
+	}
+	if len(dst) > 255 {
+		return nil, errors.New("suite: hash to curve DST too long")
+	}
+
+	// DST_prime = DST || I2OSP(len(DST), 1)
+	dstPrime := append(append([]byte{}, dst...), byte(len(dst)))
+
+	// b_0 = H(Z_pad || msg || l_i_b_str || I2OSP(0, 1) || DST_prime)
+	h.Write(make([]byte, sInBytes))
+	h.Write(msg)
+	h.Write([]byte{byte(length >> 8), byte(length), 0})
+	h.Write(dstPrime)
+	b0 := h.Sum(nil)
+
+	// b_1 = H(b_0 || I2OSP(1, 1) || DST_prime)
+	h.Reset()
+	h.Write(b0)
+	h.Write([]byte{1})
+	h.Write(dstPrime)
+	bi := h.Sum(nil)
+
+	out := append([]byte{}, bi...)
+	for i := 2; i <= ell; i++ {
+		// b_i = H(strxor(b_0, b_(i - 1)) || I2OSP(i, 1) || DST_prime)
+		x := make([]byte, bInBytes)
+		for j := range x {
+			x[j] = b0[j] ^ bi[j]
+		}
+
+		h.Reset()
+		h.Write(x)
+		h.Write([]byte{byte(i)})
+		h.Write(dstPrime)
+		bi = h.Sum(nil)
+		out = append(out, bi...)
+	}
+
+	return out[:length], nil
+}
+
+// MapToCurveSSWU is the simplified Shallue-van de Woestijne-Ulas map of RFC 9380 section 6.6.2
+func (s *Suite) MapToCurveSSWU(u *big.Int) *Point {
+	p := s.Curve.Params().P
+	a, b := s.A, s.Curve.Params().B
+	mod := func(x *big.Int) *big.Int { return x.Mod(x, p) }
+
+	// tv1 = inv0(Z^2 * u^4 + Z * u^2)
+	zu2 := mod(new(big.Int).Mul(s.Z, new(big.Int).Mul(u, u)))
+	tv1 := mod(new(big.Int).Add(new(big.Int).Mul(zu2, zu2), zu2))
+	if tv1.Sign() != 0 {
+		tv1.ModInverse(tv1, p)
+	}
+
+	// x1 = (-B / A) * (1 + tv1), or B / (Z * A) when tv1 is 0
+	var x1 *big.Int
+	if tv1.Sign() == 0 {
+		za := mod(new(big.Int).Mul(s.Z, a))
+		x1 = mod(new(big.Int).Mul(b, za.ModInverse(za, p)))
+	} else {
+		invA := new(big.Int).ModInverse(mod(new(big.Int).Set(a)), p)
+		x1 = mod(new(big.Int).Mul(new(big.Int).Neg(b), invA))
+		x1 = mod(x1.Mul(x1, new(big.Int).Add(tv1, big.NewInt(1))))
+	}
+
+	// pick x1 if gx1 is square, x2 = Z * u^2 * x1 otherwise
+	x := x1
+	y := s.solveY(x1)
+	if y == nil {
+		x = mod(new(big.Int).Mul(zu2, x1))
+		y = s.solveY(x)
+	}
+
+	// sgn0(u) == sgn0(y)
+	if u.Bit(0) != y.Bit(0) {
+		y.Sub(p, y)
+		mod(y)
+	}
+
+	return &Point{x, y}
+}
@@ -0,0 +1,67 @@
+package suite_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
+)
+
+// hashToCurveVector holds one RFC 9380 Appendix J test vector, the point is hex encoded
+type hashToCurveVector struct {
+	Suite   suite.SuiteOptions
+	DST     string
+	Msg     string
+	Uniform bool // hash_to_curve (_RO_) or encode_to_curve (_NU_)
+	X, Y    string
+}
+
+// hashToCurveVectors from RFC 9380 Appendix J.1, P256_XMD:SHA-256_SSWU_RO_ and _NU_
+var hashToCurveVectors = []hashToCurveVector{
+	{
+		Suite:   suite.P256,
+		DST:     "QUUX-V01-CS02-with-P256_XMD:SHA-256_SSWU_RO_",
+		Msg:     "",
+		Uniform: true,
+		X:       "2c15230b26dbc6fc9a37051158c95b79656e17a1a920b11394ca91c44247d3e4",
+		Y:       "8a7a74985cc5c776cdfe4b1f19884970453912e9d31528c060be9ab5c43e8415",
+	},
+	{
+		Suite:   suite.P256,
+		DST:     "QUUX-V01-CS02-with-P256_XMD:SHA-256_SSWU_RO_",
+		Msg:     "abc",
+		Uniform: true,
+		X:       "0bb8b87485551aa43ed54f009230450b492fead5f1cc91658775dac4a3388a0f",
+		Y:       "5c41b3d0731a27a7b14bc0bf0ccded2d8751f83493404c84a88e71ffd424212e",
+	},
+	{
+		Suite:   suite.P256,
+		DST:     "QUUX-V01-CS02-with-P256_XMD:SHA-256_SSWU_NU_",
+		Msg:     "",
+		Uniform: false,
+		X:       "f871caad25ea3b59c16cf87c1894902f7e7b2c822c3d3f73596c5ace8ddd14d1",
+		Y:       "87b9ae23335bee057b99bac1e68588b18b5691af476234b8971bc4f011ddc99b",
+	},
+}
+
+// TestHashToCurve maps the message of each vector and compares the point
+func TestHashToCurve(t *testing.T) {
+	for _, v := range hashToCurveVectors {
+		s := suite.SelectECCSuite(v.Suite)
+
+		mapping := s.EncodeToCurve
+		if v.Uniform {
+			mapping = s.HashToCurve
+		}
+
+		p, err := mapping([]byte(v.Msg), []byte(v.DST))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		x, y := p.X.FillBytes(make([]byte, s.ByteLen())), p.Y.FillBytes(make([]byte, s.ByteLen()))
+		if hex.EncodeToString(x) != v.X || hex.EncodeToString(y) != v.Y {
+			t.Errorf("%s, msg=%q: got (%x, %x), want (%s, %s)", v.DST, v.Msg, x, y, v.X, v.Y)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package suite
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+type MACOptions string
+
+const (
+	HMACSHA256 MACOptions = "HMAC-SHA256"
+	CMACAES128 MACOptions = "CMAC-AES-128"
+)
+
+// HMACSHA256Tag computes HMAC-SHA256 of msg under key, any key size is fine
+func HMACSHA256Tag(key, msg []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+// SelectMAC returns the tag function for the given MAC algorithm
+func SelectMAC(name MACOptions) func(key, msg []byte) ([]byte, error) {
+	switch name {
+	case HMACSHA256:
+		return HMACSHA256Tag
+	case CMACAES128:
+		return CMACAES128Tag
+	default:
+		return nil
+	}
+}
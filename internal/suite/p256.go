@@ -2,9 +2,8 @@ package suite
 
 import (
 	"crypto/elliptic"
-	"crypto/hmac"
 	"crypto/sha256"
-	"crypto/subtle"
+	"encoding/hex"
 	"io"
 	"math/big"
 
@@ -19,9 +18,15 @@ type P256Suite struct {
 // Equation for P256
 // y^2 = x^3 - 3x + 41058363725152142129326129780047268409114441015993725554835256314039467401291
 
-const L = 256 // len(Kc) defiend by RFC 9382
+const L = 256 // len(Kc) in bits defiend by RFC 9382
 var A = big.NewInt(-3)
 
+// M and N for P256 as published in RFC 9382 section 6
+const (
+	p256M = "02886e2f97ace46e55ba9dd7242579f2993b64e16ef3dcab95afd497333d8fa12f"
+	p256N = "03d8bbd6c639c62937b04d997f38c3770719c629d7014d49a24b4f98baa1292b49"
+)
+
 // NewP256Suite creates a new suite object with function and parameters for NIST P256 curve
 func NewP256Suite() *Suite {
 	// IDK how to make this easier
@@ -29,23 +34,44 @@ func NewP256Suite() *Suite {
 	s.Suite.Name = P256
 	s.Suite.Curve = elliptic.P256()
 	s.Suite.Hash = s.Hash
+	s.Suite.NewHash = sha256.New
 	s.Suite.KDF = s.KDF
-	s.Suite.MAC = s.MAC
+	s.Suite.SetMAC(HMACSHA256)
+	s.Suite.SetAEAD(AES128GCM)
 	s.Suite.L = L
 	s.Suite.A = A
+	s.Suite.H = big.NewInt(1)
+	s.Suite.M = s.mustDecodeHex(p256M)
+	s.Suite.N = s.mustDecodeHex(p256N)
+	s.Suite.Z = big.NewInt(-10)
+	s.Suite.K = 128
 
 	return &s.Suite
 }
 
+// mustDecodeHex decodes one of the constant points of the suite
+func (s *P256Suite) mustDecodeHex(str string) *Point {
+	b, err := hex.DecodeString(str)
+	if err != nil {
+		panic(err)
+	}
+
+	p, err := s.Suite.DecodePoint(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
 // Hash defines the hash function used for this suite, following RFC 9382
 func (s *P256Suite) Hash(str string) [32]byte {
 	hash := sha256.Sum256([]byte(str))
 	return hash
 }
 
-// KDF defines the Key Deriving used for this suite, following RFC 9382
-func (s *P256Suite) KDF(str string) ([]byte, []byte, []byte) {
-	hashedTranscript := s.Hash(str)
+// KDF defines the Key Deriving used for this suite from Hash(TT), following RFC 9382
+func (s *P256Suite) KDF(hashedTranscript []byte) ([]byte, []byte, []byte) {
 
 	ke := hashedTranscript[0 : len(hashedTranscript)/2]
 	ka := hashedTranscript[len(hashedTranscript)/2:]
@@ -54,24 +80,10 @@ func (s *P256Suite) KDF(str string) ([]byte, []byte, []byte) {
 	hkdf := hkdf.New(sha256.New, ka, nil, []byte("ConfirmationKeys"))
 
 	// Extract and expand the key material
-	kc := make([]byte, L)
+	kc := make([]byte, s.Suite.L/8)
 	if _, err := io.ReadFull(hkdf, kc); err != nil {
 		panic(err)
 	}
 
 	return ke, kc[0 : len(kc)/2], kc[len(kc)/2:]
 }
-
-// MAC uses RFC 9382 defined MAC function to validate received confirmation key
-func (s *P256Suite) MAC(kca []byte, kcb []byte, tt []byte) bool {
-
-	mac1 := hmac.New(sha256.New, kca)
-	mac1.Write(tt) // Include the protocol transcript
-	macA := mac1.Sum(nil)
-
-	// Party B
-	mac2 := hmac.New(sha256.New, kcb)
-	mac2.Write(tt) // Must be the same data as used by A, but why? Ke is derived from TT, this is validating samething twice
-	macb := mac2.Sum(nil)
-	return subtle.ConstantTimeCompare(macA, macb) == 1
-}
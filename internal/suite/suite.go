@@ -1,18 +1,35 @@
 package suite
 
 import (
+	"crypto/cipher"
 	"crypto/elliptic"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash"
 	"math/big"
 )
 
 type Suite struct {
-	Name  SuiteOptions
-	Curve elliptic.Curve
-	L     int      //key length
-	A     *big.Int // const A
-	Hash  func(str string) [32]byte
-	KDF   func(tt string) ([]byte, []byte, []byte)
-	MAC   func(kca []byte, kcb []byte, tt []byte) bool
+	Name    SuiteOptions
+	Curve   elliptic.Curve
+	L       int      //key length
+	A       *big.Int // const A
+	H       *big.Int // cofactor
+	M       *Point   // RFC 9382 blinding point used by A
+	N       *Point   // RFC 9382 blinding point used by B
+	Z       *big.Int // RFC 9380 simplified SWU constant for hashing to the curve
+	K       int      // security level in bits, sets how many bytes are hashed per field element
+	Hash    func(str string) [32]byte
+	NewHash func() hash.Hash // streaming form of Hash, used for the transcript
+	KDF     func(hashedTT []byte) ([]byte, []byte, []byte)
+
+	MACName MACOptions                            // MAC algorithm used for key confirmation
+	Tag     func(key, msg []byte) ([]byte, error) // computes MACName over msg
+
+	AEADName    AEADOptions                           // AEAD protecting the session after the handshake
+	NewAEAD     func(key []byte) (cipher.AEAD, error) // creates AEADName
+	AEADKeySize int
 }
 
 // GetName Return name of the suite
@@ -25,6 +42,128 @@ func (s *Suite) GetCurve() elliptic.Curve {
 	return s.Curve
 }
 
+// SetMAC swaps the MAC algorithm used for key confirmation, returns nil if the algorithm is unknown
+func (s *Suite) SetMAC(name MACOptions) *Suite {
+	tag := SelectMAC(name)
+	if tag == nil {
+		return nil
+	}
+
+	s.MACName = name
+	s.Tag = tag
+
+	return s
+}
+
+// SetAEAD swaps the AEAD used by sessions, returns nil if the algorithm is unknown
+func (s *Suite) SetAEAD(name AEADOptions) *Suite {
+	newAEAD, keySize := SelectAEAD(name)
+	if newAEAD == nil {
+		return nil
+	}
+
+	s.AEADName = name
+	s.NewAEAD = newAEAD
+	s.AEADKeySize = keySize
+
+	return s
+}
+
+// VerifyTag recomputes the MAC of msg under key and compares it with the received tag in constant time,
+// a key the MAC can't take verifies nothing
+func (s *Suite) VerifyTag(key, msg, tag []byte) bool {
+	expected, err := s.Tag(key, msg)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(expected, tag) == 1
+}
+
+// ByteLen returns the size in bytes of a field element or scalar for the suite's curve
+func (s *Suite) ByteLen() int {
+	return (s.Curve.Params().BitSize + 7) / 8
+}
+
+// EncodePoint returns the SEC1 uncompressed encoding of the point: 0x04 || X || Y
+func (s *Suite) EncodePoint(p *Point) []byte {
+	if p.IsIdentity() {
+		return []byte{0}
+	}
+
+	size := s.ByteLen()
+	out := make([]byte, 1+2*size)
+	out[0] = 4
+	p.X.FillBytes(out[1 : 1+size])
+	p.Y.FillBytes(out[1+size:])
+
+	return out
+}
+
+// EncodePointCompressed returns the SEC1 compressed encoding of the point: 0x02 or 0x03 || X
+func (s *Suite) EncodePointCompressed(p *Point) []byte {
+	if p.IsIdentity() {
+		return []byte{0}
+	}
+
+	out := make([]byte, 1+s.ByteLen())
+	out[0] = 2 | byte(p.Y.Bit(0))
+	p.X.FillBytes(out[1:])
+
+	return out
+}
+
+// EncodeScalar returns the big endian encoding of n padded to the size of the group order
+func (s *Suite) EncodeScalar(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, (s.Curve.Params().N.BitLen()+7)/8))
+}
+
+// DecodePoint parses a SEC1 compressed or uncompressed point and checks it lies on the curve
+func (s *Suite) DecodePoint(b []byte) (*Point, error) {
+	size := s.ByteLen()
+	if len(b) == 0 {
+		return nil, errors.New("empty point encoding")
+	}
+
+	p := &Point{}
+	switch {
+	case b[0] == 4 && len(b) == 1+2*size:
+		p.X = new(big.Int).SetBytes(b[1 : 1+size])
+		p.Y = new(big.Int).SetBytes(b[1+size:])
+	case (b[0] == 2 || b[0] == 3) && len(b) == 1+size:
+		p.X = new(big.Int).SetBytes(b[1:])
+		p.Y = s.solveY(p.X)
+		if p.Y == nil {
+			return nil, errors.New("compressed point is not on the curve")
+		}
+
+		// pick the root with the parity given by the prefix
+		if p.Y.Bit(0) != uint(b[0]&1) {
+			p.Y.Sub(s.Curve.Params().P, p.Y)
+		}
+	default:
+		return nil, fmt.Errorf("invalid point encoding of %d bytes", len(b))
+	}
+
+	if p.X.Cmp(s.Curve.Params().P) >= 0 || p.Y.Cmp(s.Curve.Params().P) >= 0 || !s.IsOnCurve(p) {
+		return nil, errors.New("point is not on the curve")
+	}
+
+	return p, nil
+}
+
+// solveY returns a square root of x^3 + ax + b, or nil if there isn't one
+func (s *Suite) solveY(x *big.Int) *big.Int {
+	p := s.Curve.Params().P
+
+	y2 := new(big.Int).Exp(x, big.NewInt(3), p)
+	y2.Add(y2, new(big.Int).Mul(x, s.A))
+	y2.Add(y2, s.Curve.Params().B)
+	y2.Mod(y2, p)
+
+	return new(big.Int).ModSqrt(y2, p)
+}
+
 // Add adds two points on the elliptic curve.
 func (s *Suite) Add(p1, p2 *Point) *Point {
 
@@ -32,6 +171,19 @@ func (s *Suite) Add(p1, p2 *Point) *Point {
 
 	p := s.Curve.Params().P
 
+	// the identity is represented by a point without coordinates
+	if p1.IsIdentity() {
+		return p2
+	} else if p2.IsIdentity() {
+		return p1
+	}
+
+	// p1 + (-p1), this also covers doubling a point with y = 0
+	ySum := new(big.Int).Add(p1.Y, p2.Y)
+	if p1.X.Cmp(p2.X) == 0 && ySum.Mod(ySum, p).Sign() == 0 {
+		return &Point{}
+	}
+
 	if p1.X.Cmp(p2.X) == 0 && p1.Y.Cmp(p2.Y) == 0 { // p1 is equal to p2
 		// slope = (3 * x1^2 + a) / 2 * y1
 		slope.Mul(p1.X, p1.X)                         // x1^2
@@ -128,16 +280,6 @@ func (s *Suite) Subtract(point1, point2 *Point) (resultPoint *Point) {
 	return s.Add(point1, point2.Negate(s.Curve.Params().P))
 }
 
-// HashToCurve creates a point from a given string
-func (s *Suite) HashToCurve(str string) (p *Point) {
-
-	hash := s.Hash(str)
-	x := new(big.Int).SetBytes(hash[:])
-	p = s.BaseMultiply(x)
-
-	return p
-}
-
 // IsOnCurve Checks if the provided point lies on the EC
 func (s *Suite) IsOnCurve(p *Point) bool {
 	// y ^ 2 mod p
@@ -173,8 +315,17 @@ type Point struct {
 	X, Y *big.Int
 }
 
+// IsIdentity reports whether the point is the point at infinity
+func (p *Point) IsIdentity() bool {
+	return p.X == nil || p.Y == nil
+}
+
 // Negate returns the  negated of provided point
 func (p *Point) Negate(P *big.Int) *Point {
+	if p.IsIdentity() {
+		return p
+	}
+
 	negatedY := new(big.Int).Neg(p.Y)
 	negatedY.Mod(negatedY, P) // Take the result modulo P
 
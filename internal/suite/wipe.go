@@ -0,0 +1,44 @@
+package suite
+
+import "math/big"
+
+// Wipe overwrites b with zeros
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// WipeInt overwrites the limbs of n and sets it to zero. Copies math/big made along the way
+// are out of reach, this only clears the value the caller holds.
+func WipeInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+
+	limbs := n.Bits()
+	for i := range limbs {
+		limbs[i] = 0
+	}
+	n.SetInt64(0)
+}
+
+// Clone returns a copy of p that shares no memory with it. Add and Multiply may hand back
+// one of their inputs, secrets have to be cloned before they can be wiped on their own.
+func (p *Point) Clone() *Point {
+	if p == nil || p.IsIdentity() {
+		return &Point{}
+	}
+
+	return &Point{X: new(big.Int).Set(p.X), Y: new(big.Int).Set(p.Y)}
+}
+
+// Wipe overwrites the coordinates of p, only use it on points from Clone
+func (p *Point) Wipe() {
+	if p == nil {
+		return
+	}
+
+	WipeInt(p.X)
+	WipeInt(p.Y)
+}
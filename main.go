@@ -2,14 +2,24 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
-	"math/big"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	spake2 "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2"
 	"github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2/server"
+	spake2plus "github.com/Zesheng-Xu/SPAKE2-playground/internal/SPAKE2plus"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/cpace"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/dragonfly"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/jpake"
+	"github.com/Zesheng-Xu/SPAKE2-playground/internal/opaque"
 	"github.com/Zesheng-Xu/SPAKE2-playground/internal/suite"
 )
 
@@ -17,141 +27,437 @@ var (
 	// password shared by the client and server
 	pw = "PythonISWAYBETTER"
 
-	// a prime that they chose to use
-	pp = int64(1231231234542132117)
+	// where the server listens, its endpoints are mounted under serverPrefix
+	serverAddr   = ":8080"
+	serverPrefix = "/spake2"
+	serverURL    = "http://localhost:8080" + serverPrefix
 )
 
-//TODO: implement/upgrade to SPAKE2+ once this is done
-
 // Main function.
 func main() {
 
+	// Enroll Alice, the server only keeps values derived from her password
+	credentials := server.NewMemoryCredentials()
+	cred, err := server.DeriveCredential(suite.P256, pw, "Alice", "Bob")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := credentials.Enroll("Alice", cred); err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize the server
-	s := &server.Server{}
-	err := s.Init("Bob")
+	s, err := server.New(&server.Options{Identity: "Bob", Prefix: serverPrefix, Credentials: credentials, Logger: log.Default()})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// mounted on a router of our own, next to whatever else the application serves
+	mux := http.NewServeMux()
+	mux.Handle(serverPrefix+"/", s)
+
+	// Listen before the clients below start, they can connect as soon as Listen returns
+	listener, err := net.Listen("tcp", serverAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Println("Starting the server on", listener.Addr())
 
 	go func() {
-		// Start the server
-		log.Println("Starting the server on port 8080")
-		log.Fatal(http.ListenAndServe(":8080", nil))
+		log.Fatal(http.Serve(listener, mux))
 	}()
 
-	// Create a SPAKE2HelloRequest
-	req := spake2.SPAKE2HelloRequest{
-		Identity: "Alice",
-		Suite:    suite.P256,
-		Prime:    big.NewInt(pp),
+	session, id, err := runSPAKE2()
+	if err != nil {
+		log.Fatal("SPAKE2: ", err)
 	}
+	println("SPAKE2 handshake done")
+	sendHello(session, id)
 
-	// Encode the request into JSON
-	reqBody, err := json.Marshal(req)
+	session, id, err = runSPAKE2Plus(pw)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("SPAKE2+: ", err)
 	}
+	println("SPAKE2+ handshake done")
+	sendHello(session, id)
 
-	// Send the request to the server
-	resp, err := http.Post("http://localhost:8080/hello", "application/json", bytes.NewBuffer(reqBody))
+	session, id, err = runCPace()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("CPace: ", err)
 	}
-	defer resp.Body.Close()
+	println("CPace handshake done")
+	sendHello(session, id)
 
-	// Print the response status
-	log.Println("Response status:", resp.Status)
+	session, id, err = runJPAKE()
+	if err != nil {
+		log.Fatal("J-PAKE: ", err)
+	}
+	println("J-PAKE handshake done")
+	sendHello(session, id)
 
-	// Decode the response body into the struct
+	session, id, err = runDragonfly()
+	if err != nil {
+		log.Fatal("Dragonfly: ", err)
+	}
+	println("Dragonfly handshake done")
+	sendHello(session, id)
+
+	session, id, err = runOPAQUE()
+	if err != nil {
+		log.Fatal("OPAQUE: ", err)
+	}
+	println("OPAQUE login done")
+	sendHello(session, id)
+
+	guessPasswords(s)
+
+	if err := runConn(); err != nil {
+		log.Fatal("SPAKE2 over TCP: ", err)
+	}
+}
+
+// runSPAKE2 logs Alice in with plain SPAKE2
+func runSPAKE2() (*spake2.Session, string, error) {
 	var helloResp spake2.SPAKE2HelloResponse
-	err = json.NewDecoder(resp.Body).Decode(&helloResp)
+	err := post("/hello", spake2.SPAKE2HelloRequest{Identity: "Alice", Suite: suite.P256}, &helloResp)
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
 
-	// Compute the client's public key
-	client := spake2.Participant{}
-	sharedParam := &spake2.SetUpParams{
-		Prime: big.NewInt(pp),
-		Pw:    pw,
-		Suite: suite.P256,
+	client, err := spake2.NewParticipant(suite.Client, "Alice", &spake2.SetUpParams{
+		Pw:               pw,
+		OpponentIdentity: helloResp.Identity,
+		Suite:            suite.P256,
+	})
+	if err != nil {
+		return nil, "", err
 	}
-	client.Role = suite.Client
-	client.Identity = "Alice"
-	client.SetUp(sharedParam)
-	client.OpponentIdentity = helloResp.Identity
-	PointClient, err := client.ComputepPoint()
+
+	return handshake(client, helloResp.SessionID, helloResp.State)
+}
+
+// runSPAKE2Plus logs Alice in with SPAKE2+ and the given password, the server only knows her registration record
+func runSPAKE2Plus(password string) (*spake2.Session, string, error) {
+	var helloResp spake2plus.HelloResponse
+	err := post("/spake2plus/hello", spake2plus.HelloRequest{Identity: "Alice", Suite: suite.P256}, &helloResp)
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
 
-	// Create a SPAKE2PublickeyRequest
-	pubKeyReq := spake2.SPAKE2PublickeyRequest{
-		PubliCKey: PointClient,
+	secrets, err := spake2plus.DeriveSecrets(suite.NewP256Suite(), password, "Alice", helloResp.Identity, helloResp.Salt)
+	if err != nil {
+		return nil, "", err
 	}
 
-	// Encode the request into JSON
-	reqBody, err = json.Marshal(pubKeyReq)
+	prover, err := spake2plus.NewProver("Alice", secrets, &spake2plus.SetUpParams{
+		Suite:            suite.P256,
+		Context:          helloResp.Context,
+		OpponentIdentity: helloResp.Identity,
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
 
-	// Send the public key to the server
-	resp, err = http.Post("http://localhost:8080/clientPublicKey", "application/json", bytes.NewBuffer(reqBody))
+	return handshake(prover, helloResp.SessionID, nil)
+}
+
+// runCPace logs Alice in with CPace, the server picks the session identifier. Like the other
+// symmetric PAKEs it takes the secret the server keeps in place of the password.
+func runCPace() (*spake2.Session, string, error) {
+	var helloResp cpace.HelloResponse
+	err := post("/cpace/hello", cpace.HelloRequest{Identity: "Alice", Suite: suite.P256}, &helloResp)
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
-	defer resp.Body.Close()
 
-	// Decode the response body into the struct
-	var pubKeyResp spake2.SPAKE2PublicKeyResponse
-	err = json.NewDecoder(resp.Body).Decode(&pubKeyResp)
+	initiator, err := cpace.NewParticipant(suite.Client, "Alice", &cpace.SetUpParams{
+		Suite:            suite.P256,
+		PRS:              server.PasswordSecret(suite.NewP256Suite(), pw),
+		CI:               cpace.ChannelIdentifier("Alice", helloResp.Identity),
+		SID:              helloResp.SID,
+		OpponentIdentity: helloResp.Identity,
+		AD:               []byte("Alice"),
+		PeerAD:           []byte(helloResp.Identity),
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
 
-	// Compute the shared key
-	client.ComputepGroupElement(pubKeyResp.PublicKey)
-	client.ComputeTranscript()
-	client.DeriveKeys()
+	return handshake(initiator, helloResp.SessionID, nil)
+}
 
-	// Create a SPAKE2MACRequest
-	macReq := spake2.SPAKE2MACRequest{
-		MACMessage: client.ProduceMacMessage(),
+// runJPAKE logs Alice in with J-PAKE, its two rounds take the place of the share and the MAC
+func runJPAKE() (*spake2.Session, string, error) {
+	var helloResp jpake.HelloResponse
+	err := post("/jpake/hello", jpake.HelloRequest{Identity: "Alice", Suite: suite.P256}, &helloResp)
+	if err != nil {
+		return nil, "", err
 	}
 
-	// Encode the request into JSON
-	reqBody, err = json.Marshal(macReq)
+	client, err := jpake.NewParticipant(suite.Client, "Alice", &jpake.SetUpParams{
+		Suite:            suite.P256,
+		Pw:               string(server.PasswordSecret(suite.NewP256Suite(), pw)),
+		OpponentIdentity: helloResp.Identity,
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
+	}
+
+	return handshake(client, helloResp.SessionID, nil)
+}
+
+// runDragonfly logs Alice in with Dragonfly, the server's identity salts the password token
+func runDragonfly() (*spake2.Session, string, error) {
+	var helloResp dragonfly.HelloResponse
+	err := post("/dragonfly/hello", dragonfly.HelloRequest{Identity: "Alice", Suite: suite.P256}, &helloResp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client, err := dragonfly.NewParticipant(suite.Client, "Alice", &dragonfly.SetUpParams{
+		Suite:            suite.P256,
+		Pw:               string(server.PasswordSecret(suite.NewP256Suite(), pw)),
+		SSID:             helloResp.SSID,
+		OpponentIdentity: helloResp.Identity,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return handshake(client, helloResp.SessionID, nil)
+}
+
+// runOPAQUE registers Alice with OPAQUE then logs her in, the server never learns her password
+func runOPAQUE() (*spake2.Session, string, error) {
+	param := &opaque.SetUpParams{Suite: suite.P256, Pw: pw}
+
+	registration, err := opaque.NewClient("Alice", param)
+	if err != nil {
+		return nil, "", err
+	}
+	defer registration.Close()
+
+	request, err := registration.RegistrationRequest()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var regResp opaque.RegistrationResponse
+	err = post("/opaque/register", opaque.RegistrationRequest{Identity: "Alice", Suite: suite.P256, Message: request}, &regResp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the identities and context are part of the envelope and every login
+	param.OpponentIdentity = regResp.Identity
+	param.Context = regResp.Context
+	registration.ServerIdentity = regResp.Identity
+
+	record, err := registration.FinalizeRegistration(regResp.Message)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var recordResp opaque.RegistrationRecordResponse
+	err = post("/opaque/register/finish", opaque.RegistrationRecordRequest{Identity: "Alice", Message: record}, &recordResp)
+	if err != nil {
+		return nil, "", err
+	}
+	println("Registered with OPAQUE")
+
+	client, err := opaque.NewClient("Alice", param)
+	if err != nil {
+		return nil, "", err
+	}
+	defer client.Close()
+
+	ke1, err := client.Start()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var loginResp opaque.LoginResponse
+	err = post("/opaque/login", opaque.LoginRequest{Identity: "Alice", Suite: suite.P256, Message: ke1}, &loginResp)
+	if err != nil {
+		return nil, "", err
 	}
 
-	// Send the MAC to the server
-	resp, err = http.Post("http://localhost:8080/clientMAC", "application/json", bytes.NewBuffer(reqBody))
+	// Open the envelope and check the server's MAC
+	ke3, session, err := client.Finish(loginResp.Message)
 	if err != nil {
+		return nil, "", err
+	}
+	println("Client confirmed Server Mac")
+
+	var finishResp opaque.LoginFinishResponse
+	err = post("/opaque/login/finish", opaque.LoginFinishRequest{SessionID: loginResp.SessionID, Message: ke3}, &finishResp)
+	if err != nil {
+		return nil, "", err
+	}
+	println("Server confirmed Client Mac")
+
+	return session, loginResp.SessionID, nil
+}
+
+// guessPasswords plays someone guessing Alice's password: after a few failures the server makes them
+// wait before the next guess, until Alice is unlocked
+func guessPasswords(s *server.Server) {
+	for i := 1; i <= 4; i++ {
+		if _, _, err := runSPAKE2Plus("PythonISWAYWORSE"); err != nil {
+			println("Guess", i, "refused:", err.Error())
+		}
+	}
+
+	if err := s.Unlock("Alice"); err != nil {
 		log.Fatal(err)
 	}
-	defer resp.Body.Close()
+	println("Unlocked Alice")
+}
+
+// runConn secures a plain TCP connection with SPAKE2, no HTTP involved, the server echoes one line back
+func runConn() error {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		conn, err := spake2.Server(c, &spake2.Config{Identity: "Bob", PeerIdentity: "Alice", Password: pw, Suite: suite.P256})
+		if err != nil {
+			log.Println("TCP server:", err)
+			return
+		}
+		defer conn.Close()
+
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			log.Println("TCP server:", err)
+			return
+		}
+		fmt.Fprint(conn, "Hello ", line)
+	}()
+
+	c, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	conn, err := spake2.Client(c, &spake2.Config{Identity: "Alice", PeerIdentity: "Bob", Password: pw, Suite: suite.P256})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	println("SPAKE2 over TCP handshake done")
+
+	fmt.Fprintln(conn, "Alice")
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	println("Client read over TCP:", strings.TrimSpace(reply))
+
+	return nil
+}
+
+// handshake runs the client side of h through the share and MAC endpoints of the session id, and returns
+// the session along with its ID. Plain SPAKE2 servers hand their state to the client, state is the token
+// from the hello and is passed along from there.
+func handshake(h spake2.Handshake, id string, state []byte) (*spake2.Session, string, error) {
+	// the session keeps what it needs, the handshake secrets are wiped on the way out
+	defer h.Close()
+
+	// the round trips to the server are part of the timing, like they would be for a real client
+	start := time.Now()
+	defer func() { println("Handshake took", time.Since(start).String()) }()
 
-	// Decode the response body into the struct
+	share, err := h.Start()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Send our share, the server answers with its own
+	var pubKeyResp spake2.SPAKE2PublicKeyResponse
+	err = post("/clientPublicKey", spake2.SPAKE2PublickeyRequest{SessionID: id, Message: share, State: state}, &pubKeyResp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Compute the shared key and our confirmation message
+	mac, err := h.Respond(pubKeyResp.Message)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Send the MAC to the server, it only answers with its own once ours checks out
 	var macResp spake2.SPAKE2MACResponse
-	err = json.NewDecoder(resp.Body).Decode(&macResp)
+	err = post("/clientMAC", spake2.SPAKE2MACRequest{SessionID: id, Message: mac, State: pubKeyResp.State}, &macResp)
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
 	println("Server confirmed Client Mac")
 
 	// Confirm the server's MAC
-	confirm, err := client.ConfirmMAC(macResp.MACMessage)
-	if err != nil || !confirm {
-		log.Fatal("Error while confirming server MAC message")
+	session, err := h.Finish(macResp.Message)
+	if err != nil {
+		return nil, "", fmt.Errorf("confirming server MAC message: %w", err)
 	}
 	println("Client confirmed Server Mac")
 
-	// Now you can encrypt and decrypt messages using the derived keys
-	message, _ := client.Encrypt([]byte("Hello World"))
-	println("Client send encrypted text:" + string(message))
+	return session, id, nil
+}
+
+// sendHello shows the derived keys at work, the server opens our message with the session named by id
+// and seals its answer
+func sendHello(session *spake2.Session, id string) {
+	// the demo is done with the session after one message
+	defer session.Close()
+
+	aad := []byte(session.Identity)
+
+	message, err := session.Seal([]byte("Hello World"), aad)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Client send encrypted text: %x\n", message)
+
+	var msgResp spake2.SPAKE2MessageResponse
+	err = post("/message", spake2.SPAKE2MessageRequest{SessionID: id, Message: message}, &msgResp)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reply, err := session.Open(msgResp.Message, aad)
+	if err != nil {
+		log.Fatal(err)
+	}
+	println("Client decrypted reply:", string(reply))
+}
+
+// post sends req to the server as JSON and decodes the answer into res
+func post(path string, req, res any) error {
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(serverURL+path, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
 
-	message, _ = client.Decrypt(message)
-	println("Server decrypted text:" + string(message))
+	return json.NewDecoder(resp.Body).Decode(res)
 }